@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/glennyonemitsu/mite"
+)
+
+var (
+	outputPath = flag.String("o", "", "write output to file instead of stdout")
+	printTree  = flag.Bool("tree", false, "print the parsed node tree instead of HTML")
+	sortAttrs  = flag.Bool("sort-attrs", false, "write attributes in alphabetical order")
+	dataPath   = flag.String("data", "", "JSON file with the data for the template")
+	goTemplate = flag.String("go", "", "write Go template source instead of HTML: text or html")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: mite [-o output] [template]\n")
+	fmt.Fprintf(os.Stderr, "       mite gen [-pkg name] [-func name] [-type type] [-o output] template\n\n")
+	fmt.Fprintf(os.Stderr, "Compiles a mite template to HTML. Reads from stdin if no template\n")
+	fmt.Fprintf(os.Stderr, "is given. mite gen writes Go code that renders the template instead.\n\n")
+	flag.PrintDefaults()
+}
+
+// gen is the mite gen command, which writes a Go file with a render function
+// for a template.
+func gen(args []string) {
+	flags := flag.NewFlagSet("gen", flag.ExitOnError)
+	pkg := flags.String("pkg", "templates", "package name of the generated file")
+	funcName := flags.String("func", "", "name of the render function (default Render followed by the template name)")
+	dataType := flags.String("type", "", "type of the data parameter (default *, the template name and Data)")
+	output := flags.String("o", "", "write the Go file to output instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: mite gen [-pkg name] [-func name] [-type type] [-o output] template\n\n")
+		fmt.Fprintf(os.Stderr, "Writes a Go file with a function that renders the template.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	filename := flags.Arg(0)
+	t, err := mite.CompileFile(filename)
+	if err != nil {
+		fatal(err)
+	}
+	name := goName(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	opts := mite.GenOptions{Package: *pkg, Func: *funcName, DataType: *dataType}
+	if opts.Func == "" {
+		opts.Func = "Render" + name
+	}
+	if opts.DataType == "" {
+		opts.DataType = "*" + name + "Data"
+	}
+	src, err := t.Generate(opts)
+	if err != nil {
+		fatal(err)
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = os.WriteFile(*output, src, 0666)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// goName turns a file name like user-list into an exported Go name like
+// UserList.
+func goName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, ch := range s {
+		switch {
+		case unicode.IsLetter(ch) || unicode.IsDigit(ch):
+			if upper {
+				ch = unicode.ToUpper(ch)
+				upper = false
+			}
+			b.WriteRune(ch)
+		default:
+			upper = true
+		}
+	}
+	return b.String()
+}
+
+func fatal(err error) {
+	if list, ok := err.(mite.ErrorList); ok {
+		for _, e := range list {
+			fmt.Fprintf(os.Stderr, "%s\n", e)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "mite: %s\n", err)
+	}
+	os.Exit(1)
+}
+
+func writeTree(w io.Writer, n *mite.Node, depth int) error {
+	if _, err := fmt.Fprintf(w, "%s%s\n", strings.Repeat("    ", depth), n.Debug()); err != nil {
+		return err
+	}
+	for _, c := range n.Children {
+		if err := writeTree(w, c, depth+1); err != nil {
+			return err
+		}
+	}
+	if n.Else != nil {
+		return writeTree(w, n.Else, depth)
+	}
+	return nil
+}
+
+func writeGoTemplate(w io.Writer, t *mite.Template, flavor string) error {
+	var src string
+	var err error
+	switch flavor {
+	case "text":
+		src, err = t.TextTemplate()
+	case "html":
+		src, err = t.HTMLTemplate()
+	default:
+		return fmt.Errorf("unknown -go value %q, want text or html", flavor)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, src)
+	return err
+}
+
+func readData(filename string) (interface{}, error) {
+	var data interface{}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&data); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return data, nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		gen(os.Args[2:])
+		return
+	}
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var t *mite.Template
+	var err error
+	if flag.NArg() == 1 && flag.Arg(0) != "-" {
+		t, err = mite.CompileFile(flag.Arg(0))
+	} else {
+		t, err = mite.Compile(os.Stdin)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	t.SortAttrs = *sortAttrs
+
+	var data interface{}
+	if *dataPath != "" {
+		if data, err = readData(*dataPath); err != nil {
+			fatal(err)
+		}
+	}
+
+	out := os.Stdout
+	if *outputPath != "" {
+		out, err = os.Create(*outputPath)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if *printTree {
+		err = writeTree(out, t.Root, 0)
+	} else if *goTemplate != "" {
+		err = writeGoTemplate(out, t, *goTemplate)
+	} else if err = t.Execute(out, data); err == nil {
+		_, err = fmt.Fprintln(out)
+	}
+	if *outputPath != "" {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
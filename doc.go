@@ -0,0 +1,11 @@
+// Package mite implements mite, a minimal templating language inspired by
+// slim and jade that compiles to HTML.
+//
+// Basic usage pattern:
+//
+//	t, err := mite.Compile(src)
+//	if err != nil {
+//		// handle the error
+//	}
+//	err = t.Render(w)
+package mite
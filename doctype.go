@@ -0,0 +1,61 @@
+package mite
+
+import (
+	"strings"
+)
+
+// OutputMode is the kind of markup a template is rendered as. It decides how
+// elements without content are written.
+type OutputMode int
+
+const (
+	ModeHTML OutputMode = iota
+	ModeXHTML
+	ModeXML
+)
+
+var OutputModeString = map[OutputMode]string {
+	ModeHTML:	"HTML",
+	ModeXHTML:	"XHTML",
+	ModeXML:	"XML",
+}
+
+func (m OutputMode) String() string {
+	if mode, found := OutputModeString[m]; found {
+		return mode
+	}
+	return "???"
+}
+
+type doctype struct {
+	decl string
+	mode OutputMode
+}
+
+// doctypes maps the value of a doctype line to its declaration
+var doctypes = map[string]doctype {
+	"":				{"<!DOCTYPE html>", ModeHTML},
+	"html":			{"<!DOCTYPE html>", ModeHTML},
+	"5":			{"<!DOCTYPE html>", ModeHTML},
+	"xml":			{"<?xml version=\"1.0\" encoding=\"utf-8\" ?>", ModeXML},
+	"transitional":	{"<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\">", ModeXHTML},
+	"strict":		{"<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Strict//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd\">", ModeXHTML},
+	"frameset":		{"<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Frameset//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-frameset.dtd\">", ModeXHTML},
+	"1.1":			{"<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.1//EN\" \"http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd\">", ModeXHTML},
+	"basic":		{"<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML Basic 1.1//EN\" \"http://www.w3.org/TR/xhtml-basic/xhtml-basic11.dtd\">", ModeXHTML},
+	"mobile":		{"<!DOCTYPE html PUBLIC \"-//WAPFORUM//DTD XHTML Mobile 1.2//EN\" \"http://www.openmobilealliance.org/tech/DTD/xhtml-mobile12.dtd\">", ModeXHTML},
+}
+
+// lookupDoctype returns the declaration and output mode for the value of a
+// doctype line. "xml" can be followed by an encoding, and unknown values are
+// written as a custom <!DOCTYPE value>.
+func lookupDoctype(value string) doctype {
+	if d, found := doctypes[strings.ToLower(value)]; found {
+		return d
+	}
+	fields := strings.Fields(value)
+	if len(fields) == 2 && strings.ToLower(fields[0]) == "xml" {
+		return doctype{"<?xml version=\"1.0\" encoding=\"" + fields[1] + "\" ?>", ModeXML}
+	}
+	return doctype{"<!DOCTYPE " + value + ">", ModeHTML}
+}
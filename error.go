@@ -0,0 +1,43 @@
+package mite
+
+import (
+	"fmt"
+)
+
+// Error is a problem found in a template, reported with the position of the
+// offending source.
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// ErrorList is the list of every Error found while compiling a template.
+type ErrorList []*Error
+
+// Add appends an Error for the given position and message.
+func (l *ErrorList) Add(pos Position, msg string) {
+	*l = append(*l, &Error{pos, msg})
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns an error equivalent to this error list. If the list is empty,
+// Err returns nil.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
@@ -0,0 +1,30 @@
+package mite
+
+import (
+	"strings"
+)
+
+var textEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+)
+
+// attribute values are always quoted, so quotes need escaping as well
+var attrEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"'", "&#39;",
+	"\"", "&#34;",
+)
+
+// escapeText escapes s for use as the text content of an element.
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// escapeAttr escapes s for use as a quoted attribute value.
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
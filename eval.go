@@ -0,0 +1,452 @@
+package mite
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	fmtStringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// evalError returns an error for a problem evaluating e.
+func evalError(e Expr, format string, args ...interface{}) error {
+	return posError(e.Pos(), format, args...)
+}
+
+// posError returns an error at pos, such as the operator of a Binary.
+func posError(pos Position, format string, args ...interface{}) error {
+	return ErrorList{&Error{pos, fmt.Sprintf(format, args...)}}
+}
+
+// eval returns the value of e. An invalid reflect.Value is returned for
+// missing map keys, which are rendered as nothing.
+func (r *renderer) eval(e Expr) (reflect.Value, error) {
+	switch e := e.(type) {
+	case *Ident:
+		if v, found := r.lookupVar(e.Name); found {
+			return v, nil
+		}
+		if !r.data.IsValid() {
+			return reflect.Value{}, evalError(e, "undefined: %s", e.Name)
+		}
+		return r.evalField(r.data, e.Name, e)
+	case *Field:
+		x, err := r.eval(e.X)
+		if err != nil {
+			return x, err
+		}
+		if e.Safe && !indirect(x).IsValid() {
+			return reflect.Value{}, nil
+		}
+		return r.evalField(x, e.Name, e)
+	case *Call:
+		return r.evalCall(e)
+	case *Pipe:
+		args := append(append([]Expr{}, e.Args...), e.X)
+		return r.evalCall(&Call{e.Fun, e.Bar, args})
+	case *Paren:
+		return r.eval(e.X)
+	case *callerExpr:
+		r.calls.enterContent(len(r.vars))
+		defer r.calls.leaveContent()
+		return r.eval(e.X)
+	case *Unary:
+		return r.evalUnary(e)
+	case *Binary:
+		return r.evalBinary(e)
+	case *Index:
+		x, err := r.eval(e.X)
+		if err != nil {
+			return x, err
+		}
+		index, err := r.eval(e.Index)
+		if err != nil {
+			return index, err
+		}
+		return r.evalIndex(x, index, e)
+	case *Literal:
+		return reflect.ValueOf(e.Value), nil
+	}
+	return reflect.Value{}, evalError(e, "can't evaluate %s", e)
+}
+
+// indirect follows pointers and interfaces to the value they point to. It
+// returns an invalid reflect.Value if one of them is nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// evalField returns the method, struct field or map value called name of v.
+// Methods can have no arguments, and return a value and optionally an error.
+func (r *renderer) evalField(v reflect.Value, name string, e Expr) (reflect.Value, error) {
+	if !v.IsValid() {
+		return v, evalError(e, "can't evaluate %s: value is nil", e)
+	}
+
+	// methods can be on the pointer or the value it points to
+	if method := findMethod(v, name); method.IsValid() {
+		return callMethod(method, e)
+	}
+
+	v = indirect(v)
+	if !v.IsValid() {
+		return v, evalError(e, "can't evaluate %s: value is nil", e)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if field, found := v.Type().FieldByName(name); found && field.PkgPath == "" {
+			return v.FieldByIndex(field.Index), nil
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			key := reflect.ValueOf(name).Convert(v.Type().Key())
+			return v.MapIndex(key), nil
+		}
+	}
+	return reflect.Value{}, evalError(e, "can't evaluate %s: no field %s in type %s", e, name, v.Type())
+}
+
+func callMethod(method reflect.Value, e Expr) (reflect.Value, error) {
+	t := method.Type()
+	if t.NumIn() != 0 || t.NumOut() == 0 || t.NumOut() > 2 || t.NumOut() == 2 && t.Out(1) != errorType {
+		return reflect.Value{}, evalError(e, "can't evaluate %s: method must take no arguments and return a value and an optional error", e)
+	}
+	return safeCall(method, nil, e, e)
+}
+
+// evalIndex returns the element of slice, array, string or map v at index.
+func (r *renderer) evalIndex(v, index reflect.Value, e Expr) (reflect.Value, error) {
+	v = indirect(v)
+	index = indirect(index)
+	if !v.IsValid() {
+		return v, evalError(e, "can't index %s: value is nil", e)
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String:
+		i, ok := toInt(index)
+		if !ok {
+			return reflect.Value{}, evalError(e, "can't index %s: index is not an integer", e)
+		}
+		if i < 0 || i >= v.Len() {
+			return reflect.Value{}, evalError(e, "can't index %s: index %d out of range", e, i)
+		}
+		return v.Index(i), nil
+	case reflect.Map:
+		if !index.IsValid() {
+			return reflect.Value{}, nil
+		}
+		keyType := v.Type().Key()
+		if !index.Type().AssignableTo(keyType) {
+			if !index.Type().ConvertibleTo(keyType) || index.Kind() != keyType.Kind() && !isNumber(index) {
+				return reflect.Value{}, evalError(e, "can't index %s: key of type %s is not %s", e, index.Type(), keyType)
+			}
+			index = index.Convert(keyType)
+		}
+		return v.MapIndex(index), nil
+	}
+	return reflect.Value{}, evalError(e, "can't index %s of type %s", e, v.Type())
+}
+
+func isNumber(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// toInt returns v as an int if it is an integer, or a float with no fraction.
+func toInt(v reflect.Value) (int, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); f == float64(int(f)) {
+			return int(f), true
+		}
+	}
+	return 0, false
+}
+
+// printValue returns the text of v for the output, the same as fmt.Print
+// except pointers are printed as what they point to.
+func printValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		if v.Kind() == reflect.Ptr && (v.Type().Implements(fmtStringerType) || v.Type().Implements(errorType)) {
+			break
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func (r *renderer) evalUnary(e *Unary) (reflect.Value, error) {
+	x, err := r.eval(e.X)
+	if err != nil {
+		return x, err
+	}
+	if e.Op == "!" {
+		return reflect.ValueOf(!truth(x)), nil
+	}
+	x = unwrap(x)
+	switch {
+	case isInt(x):
+		return reflect.ValueOf(-toInt64(x)), nil
+	case isNumber(x):
+		return reflect.ValueOf(-toFloat(x)), nil
+	}
+	return reflect.Value{}, posError(e.OpPos, "invalid operation: %s (operator - not defined on %s)", e, typeName(x))
+}
+
+func (r *renderer) evalBinary(e *Binary) (reflect.Value, error) {
+	x, err := r.eval(e.X)
+	if err != nil {
+		return x, err
+	}
+	// && and || only evaluate Y if needed
+	switch e.Op {
+	case "&&":
+		if !truth(x) {
+			return reflect.ValueOf(false), nil
+		}
+	case "||":
+		if truth(x) {
+			return reflect.ValueOf(true), nil
+		}
+	}
+	y, err := r.eval(e.Y)
+	if err != nil {
+		return y, err
+	}
+	x, y = unwrap(x), unwrap(y)
+
+	switch e.Op {
+	case "&&", "||":
+		return reflect.ValueOf(truth(y)), nil
+	case "==", "!=":
+		eq, ok := equal(x, y)
+		if !ok && x.IsValid() && y.IsValid() && x.Type() == y.Type() {
+			return reflect.Value{}, posError(e.OpPos, "invalid operation: %s (operator %s not defined on %s)", e, e.Op, typeName(x))
+		}
+		if !ok {
+			return reflect.Value{}, mismatched(e, x, y)
+		}
+		return reflect.ValueOf(eq == (e.Op == "==")), nil
+	case "<", "<=", ">", ">=":
+		return compare(e, x, y)
+	}
+	return arithmetic(e, x, y)
+}
+
+// equal reports whether x and y are equal, and false for ok if they can't be
+// compared. nil equals missing values and nil pointers, slices and maps.
+func equal(x, y reflect.Value) (eq, ok bool) {
+	switch {
+	case !x.IsValid() || !y.IsValid():
+		return isNil(x) && isNil(y), true
+	case isNumber(x) && isNumber(y):
+		if isInt(x) && isInt(y) {
+			return toInt64(x) == toInt64(y), true
+		}
+		return toFloat(x) == toFloat(y), true
+	case x.Kind() == reflect.String && y.Kind() == reflect.String:
+		return x.String() == y.String(), true
+	case x.Kind() == reflect.Bool && y.Kind() == reflect.Bool:
+		return x.Bool() == y.Bool(), true
+	case x.Type() == y.Type() && x.Comparable() && y.Comparable():
+		return x.Interface() == y.Interface(), true
+	}
+	return false, false
+}
+
+// compare evaluates the ordering operator of e on numbers or strings.
+func compare(e *Binary, x, y reflect.Value) (reflect.Value, error) {
+	var c int
+	switch {
+	case isNumber(x) && isNumber(y):
+		if isInt(x) && isInt(y) {
+			c = compareOrder(toInt64(x) < toInt64(y), toInt64(x) > toInt64(y))
+		} else {
+			c = compareOrder(toFloat(x) < toFloat(y), toFloat(x) > toFloat(y))
+		}
+	case x.Kind() == reflect.String && y.Kind() == reflect.String:
+		c = strings.Compare(x.String(), y.String())
+	case x.IsValid() && y.IsValid() && x.Type() == y.Type():
+		return reflect.Value{}, posError(e.OpPos, "invalid operation: %s (operator %s not defined on %s)", e, e.Op, typeName(x))
+	default:
+		return reflect.Value{}, mismatched(e, x, y)
+	}
+	var result bool
+	switch e.Op {
+	case "<":
+		result = c < 0
+	case "<=":
+		result = c <= 0
+	case ">":
+		result = c > 0
+	case ">=":
+		result = c >= 0
+	}
+	return reflect.ValueOf(result), nil
+}
+
+func compareOrder(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// arithmetic evaluates the arithmetic operator of e. Integers stay integers
+// unless one side is a float, and + also joins strings.
+func arithmetic(e *Binary, x, y reflect.Value) (reflect.Value, error) {
+	if e.Op == "+" && x.Kind() == reflect.String && y.Kind() == reflect.String {
+		return reflect.ValueOf(x.String() + y.String()), nil
+	}
+	if !isNumber(x) || !isNumber(y) {
+		if x.IsValid() && y.IsValid() && x.Type() == y.Type() {
+			return reflect.Value{}, posError(e.OpPos, "invalid operation: %s (operator %s not defined on %s)", e, e.Op, typeName(x))
+		}
+		return reflect.Value{}, mismatched(e, x, y)
+	}
+
+	if isInt(x) && isInt(y) {
+		a, b := toInt64(x), toInt64(y)
+		switch e.Op {
+		case "+":
+			return reflect.ValueOf(int(a + b)), nil
+		case "-":
+			return reflect.ValueOf(int(a - b)), nil
+		case "*":
+			return reflect.ValueOf(int(a * b)), nil
+		}
+		if b == 0 {
+			return reflect.Value{}, posError(e.OpPos, "division by zero in %s", e)
+		}
+		if e.Op == "/" {
+			return reflect.ValueOf(int(a / b)), nil
+		}
+		return reflect.ValueOf(int(a % b)), nil
+	}
+
+	a, b := toFloat(x), toFloat(y)
+	switch e.Op {
+	case "+":
+		return reflect.ValueOf(a + b), nil
+	case "-":
+		return reflect.ValueOf(a - b), nil
+	case "*":
+		return reflect.ValueOf(a * b), nil
+	case "/":
+		if b == 0 {
+			return reflect.Value{}, posError(e.OpPos, "division by zero in %s", e)
+		}
+		return reflect.ValueOf(a / b), nil
+	}
+	return reflect.Value{}, posError(e.OpPos, "invalid operation: %s (operator %% not defined on float)", e)
+}
+
+func mismatched(e *Binary, x, y reflect.Value) error {
+	return posError(e.OpPos, "invalid operation: %s (mismatched types %s and %s)", e, typeName(x), typeName(y))
+}
+
+// typeName returns the type of v for errors, which is nil for missing values.
+func typeName(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
+
+// unwrap returns the value in the interface v, if it is one.
+func unwrap(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func toInt64(v reflect.Value) int64 {
+	if v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uintptr {
+		return int64(v.Uint())
+	}
+	return v.Int()
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		return v.Float()
+	case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uintptr:
+		return float64(v.Uint())
+	}
+	return float64(v.Int())
+}
+
+// truth reports whether v is true in a condition. Like text/template, false,
+// zero numbers, nil pointers and interfaces, and empty strings, arrays, slices
+// and maps are false. Everything else is true.
+func truth(v reflect.Value) bool {
+	v = unwrap(v)
+	if !v.IsValid() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() > 0
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Complex64, reflect.Complex128:
+		return v.Complex() != 0
+	case reflect.Chan, reflect.Func, reflect.Ptr, reflect.Interface:
+		return !v.IsNil()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() != 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() != 0
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package mite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var evalData = map[string]interface{}{
+	"n": 3,
+	"f": 1.5,
+	"s": "ab",
+	"user": nil,
+	"u": map[string]interface{}{"name": "Ann"},
+	"list": []int{1, 2, 3},
+}
+
+var evalTests = []struct {
+	src string
+	want string
+	err string
+}{
+	// precedence
+	{src: "p #{1 + 2 * 3}", want: "<p>7</p>"},
+	{src: "p #{(1 + 2) * 3}", want: "<p>9</p>"},
+	{src: "p #{10 - 4 - 3}", want: "<p>3</p>"},
+	{src: "p #{1 + 2 * 3 - 4 / 2}", want: "<p>5</p>"},
+	{src: "p #{-n + 1}", want: "<p>-2</p>"},
+	{src: "p #{1 < 2 == true}", want: "<p>true</p>"},
+	{src: "p #{1 + 2 == 3 && 2 > 1}", want: "<p>true</p>"},
+	{src: "p #{f > n || n > 2}", want: "<p>true</p>"},
+
+	// int and float
+	{src: "p #{7 / 2}", want: "<p>3</p>"},
+	{src: "p #{7 / 2.0}", want: "<p>3.5</p>"},
+	{src: "p #{7 % 3}", want: "<p>1</p>"},
+	{src: "p #{n + f} #{n * f}", want: "<p>4.5 4.5</p>"},
+	{src: "p #{n == 3.0} #{f < n}", want: "<p>true true</p>"},
+
+	// ?. and indexing
+	{src: "p #{user?.name}", want: "<p></p>"},
+	{src: "p #{u?.name}", want: "<p>Ann</p>"},
+	{src: "p #{list[1]}", want: "<p>2</p>"},
+
+	// errors and their positions
+	{src: "p #{user.name}", err: "1:5: can't evaluate user.name: value is nil"},
+	{src: "p #{n.x}", err: "1:5: can't evaluate n.x: no field x in type int"},
+	{src: "p #{s + 1}", err: "1:7: invalid operation: s + 1 (mismatched types string and int)"},
+	{src: "p #{n == \"3\"}", err: "1:7: invalid operation: n == \"3\" (mismatched types int and string)"},
+	{src: "div\n  p a #{1 / 0}", err: "2:11: division by zero in 1 / 0"},
+	{src: "a href=#{n.x}", err: "1:10: can't evaluate n.x: no field x in type int"},
+	{src: "p #{1 +}", err: "1:8: unexpected end of expression"},
+	{src: "p #{a ? b}", err: "1:7: unexpected ? in expression"},
+	{src: "if n ==\n  p", err: "1:8: unexpected end of expression"},
+}
+
+func TestEval(t *testing.T) {
+	for _, test := range evalTests {
+		var b bytes.Buffer
+		tmpl, err := Compile(strings.NewReader(test.src))
+		if err == nil {
+			err = tmpl.Execute(&b, evalData)
+		}
+		switch {
+		case test.err != "":
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: error %v, want %q", test.src, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%q: %s", test.src, err)
+		case b.String() != test.want:
+			t.Errorf("%q: output %q, want %q", test.src, b.String(), test.want)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package mite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expr is an expression in a template, such as the user.Name of #{user.Name}.
+type Expr interface {
+	Pos() Position
+	String() string
+}
+
+// Ident is a name, looked up in the data passed to Execute.
+type Ident struct {
+	NamePos Position
+	Name string
+}
+
+// Field is X.Name, a struct field, method or map key of X. With Safe it is
+// X?.Name, which is nil instead of an error when X is nil.
+type Field struct {
+	X Expr
+	Name string
+	Safe bool
+}
+
+// Index is X[Index], an element of a slice, array, string or map.
+type Index struct {
+	X Expr
+	Index Expr
+}
+
+// Literal is a string, integer, floating point or boolean constant, or nil.
+type Literal struct {
+	ValuePos Position
+	// string, int, float64, bool or nil
+	Value interface{}
+	Text string
+}
+
+// Call is Fun(Args), a call of a function added with Template.Funcs, or of a
+// method with arguments when Fun is a Field.
+type Call struct {
+	Fun Expr
+	Lparen Position
+	Args []Expr
+}
+
+// Pipe is X | Fun Args, a call of Fun with the value of X as the last argument
+// after Args, like the pipelines of text/template.
+type Pipe struct {
+	X Expr
+	Bar Position
+	Fun *Ident
+	Args []Expr
+}
+
+// Paren is (X).
+type Paren struct {
+	Lparen Position
+	X Expr
+}
+
+// Unary is Op X, where Op is ! or -.
+type Unary struct {
+	OpPos Position
+	Op string
+	X Expr
+}
+
+// Binary is X Op Y, where Op is a comparison, boolean or arithmetic operator.
+type Binary struct {
+	X Expr
+	OpPos Position
+	Op string
+	Y Expr
+}
+
+func (e *Ident) Pos() Position   { return e.NamePos }
+func (e *Field) Pos() Position   { return e.X.Pos() }
+func (e *Index) Pos() Position   { return e.X.Pos() }
+func (e *Literal) Pos() Position { return e.ValuePos }
+func (e *Call) Pos() Position    { return e.Fun.Pos() }
+func (e *Pipe) Pos() Position    { return e.X.Pos() }
+func (e *Paren) Pos() Position   { return e.Lparen }
+func (e *Unary) Pos() Position   { return e.OpPos }
+func (e *Binary) Pos() Position  { return e.X.Pos() }
+
+func (e *Ident) String() string   { return e.Name }
+func (e *Field) String() string {
+	if e.Safe {
+		return e.X.String() + "?." + e.Name
+	}
+	return e.X.String() + "." + e.Name
+}
+func (e *Index) String() string   { return e.X.String() + "[" + e.Index.String() + "]" }
+func (e *Literal) String() string { return e.Text }
+func (e *Call) String() string {
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	return e.Fun.String() + "(" + strings.Join(args, ", ") + ")"
+}
+func (e *Pipe) String() string {
+	s := e.X.String() + " | " + e.Fun.String()
+	for _, arg := range e.Args {
+		s += " " + arg.String()
+	}
+	return s
+}
+func (e *Paren) String() string   { return "(" + e.X.String() + ")" }
+func (e *Unary) String() string   { return e.Op + e.X.String() }
+func (e *Binary) String() string  { return e.X.String() + " " + e.Op + " " + e.Y.String() }
+
+// precedence of the binary operators. higher binds tighter
+var precedence = map[string]int {
+	"||":	1,
+	"&&":	2,
+	"==":	3,
+	"!=":	3,
+	"<":	3,
+	"<=":	3,
+	">":	3,
+	">=":	3,
+	"+":	4,
+	"-":	4,
+	"*":	5,
+	"/":	5,
+	"%":	5,
+}
+
+// operators of two characters, by their first character
+var operators = map[rune]string {
+	'=':	"==",
+	'!':	"!=",
+	'<':	"<=",
+	'>':	">=",
+	'&':	"&&",
+	'|':	"||",
+	'?':	"?.",
+}
+
+// exprParser parses expressions with its own Scanner over the expression
+// source. Positions are translated to where the expression is in the template.
+type exprParser struct {
+	s Scanner
+
+	// position of the start of the expression source in the template
+	base Position
+
+	// current token
+	tok rune
+	text string
+	pos Position
+
+	errors ErrorList
+}
+
+// ParseExpr parses the expression src. pos is the position of src in the
+// template, used for the positions of the expression and its errors.
+func ParseExpr(src string, pos Position) (Expr, error) {
+	var p exprParser
+	p.init(src, pos)
+	e := p.parseExpr()
+	if p.tok != TokEOF {
+		p.unexpected()
+	}
+	if err := p.errors.Err(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *exprParser) init(src string, pos Position) {
+	p.base = pos
+	p.s.Init(strings.NewReader(src))
+	p.s.Mode = ScanWords | ScanInts | ScanFloats | ScanStrings | ScanWhitespace
+	p.s.Error = func(s *Scanner, msg string) {
+		p.error(p.translate(s.Pos()), msg)
+	}
+	p.next()
+}
+
+// translate turns a position within the expression source into a position in
+// the template.
+func (p *exprParser) translate(pos Position) Position {
+	pos.Filename = p.base.Filename
+	pos.Offset += p.base.Offset
+	if pos.Line == 1 {
+		pos.Column += p.base.Column - 1
+	}
+	pos.Line += p.base.Line - 1
+	return pos
+}
+
+func (p *exprParser) error(pos Position, msg string) {
+	p.errors.Add(pos, msg)
+}
+
+func (p *exprParser) unexpected() {
+	if p.tok == TokEOF {
+		p.error(p.pos, "unexpected end of expression")
+	} else {
+		p.error(p.pos, fmt.Sprintf("unexpected %s in expression", p.text))
+	}
+	// skip the rest so only one error is reported
+	for p.tok != TokEOF {
+		p.next()
+	}
+}
+
+// next moves to the next token, skipping whitespace. Operators of two
+// characters are one token, with the first character as tok.
+func (p *exprParser) next() {
+	for {
+		p.tok = p.s.Scan()[0]
+		if p.tok != TokWhitespace {
+			break
+		}
+	}
+	p.text = p.s.TokenText()
+	p.pos = p.translate(p.s.Position)
+	first := p.tok
+	if first == TokAssign {
+		first = '='
+	}
+	if op, found := operators[first]; found {
+		if p.s.Peek() == rune(op[1]) {
+			p.s.Next()
+			p.text = op
+		}
+	}
+}
+
+// isOperator reports whether the current token is punctuation rather than a
+// word, number or string.
+func (p *exprParser) isOperator() bool {
+	return p.tok >= 0 || p.tok == TokAssign
+}
+
+func (p *exprParser) expect(tok rune) {
+	if p.tok != tok {
+		p.unexpected()
+		return
+	}
+	p.next()
+}
+
+func (p *exprParser) parseExpr() Expr {
+	x := p.parseBinary(1)
+	for p.isOperator() && p.text == "|" {
+		x = p.parsePipe(x)
+	}
+	return x
+}
+
+// parsePipe parses the | name args... after x. The arguments are separated by
+// whitespace, so ones with binary operators need parentheses.
+func (p *exprParser) parsePipe(x Expr) Expr {
+	pipe := &Pipe{X: x, Bar: p.pos}
+	p.next()
+	if p.tok != TokWord {
+		p.unexpected()
+		return x
+	}
+	pipe.Fun = &Ident{p.pos, p.text}
+	p.next()
+	for p.tok != TokEOF && p.tok != TokComma && !(p.isOperator() && strings.Contains("|)]", p.text)) {
+		pipe.Args = append(pipe.Args, p.parseUnary())
+		if p.errors != nil {
+			break
+		}
+	}
+	return pipe
+}
+
+// parseBinary parses operands joined by binary operators of at least prec.
+func (p *exprParser) parseBinary(prec int) Expr {
+	x := p.parseUnary()
+	for {
+		opPrec := precedence[p.text]
+		if !p.isOperator() || opPrec < prec {
+			return x
+		}
+		pos, op := p.pos, p.text
+		p.next()
+		y := p.parseBinary(opPrec + 1)
+		x = &Binary{x, pos, op, y}
+	}
+}
+
+func (p *exprParser) parseUnary() Expr {
+	if p.isOperator() && (p.text == "!" || p.text == "-") {
+		pos, op := p.pos, p.text
+		p.next()
+		return &Unary{pos, op, p.parseUnary()}
+	}
+	return p.parsePostfix(p.parseOperand())
+}
+
+func (p *exprParser) parseOperand() Expr {
+	pos, text := p.pos, p.text
+	switch p.tok {
+	case TokWord:
+		p.next()
+		switch text {
+		case "true":
+			return &Literal{pos, true, text}
+		case "false":
+			return &Literal{pos, false, text}
+		case "nil":
+			return &Literal{pos, nil, text}
+		}
+		return &Ident{pos, text}
+	case '(':
+		p.next()
+		x := p.parseExpr()
+		p.expect(')')
+		return &Paren{pos, x}
+	case TokString:
+		p.next()
+		value, err := unquoteString(text)
+		if err != nil {
+			p.error(pos, "invalid string literal "+text)
+		}
+		return &Literal{pos, value, text}
+	case TokInt:
+		p.next()
+		value, err := strconv.ParseInt(text, 0, 0)
+		if err != nil {
+			p.error(pos, "invalid integer literal "+text)
+		}
+		return &Literal{pos, int(value), text}
+	case TokFloat:
+		p.next()
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.error(pos, "invalid number literal "+text)
+		}
+		return &Literal{pos, value, text}
+	}
+	p.unexpected()
+	return &Literal{pos, "", ""}
+}
+
+// parsePostfix parses any field access, indexing and calls following x.
+func (p *exprParser) parsePostfix(x Expr) Expr {
+	for {
+		switch {
+		case p.text == "." || p.text == "?.":
+			safe := p.text == "?."
+			p.next()
+			if p.tok != TokWord {
+				p.unexpected()
+				return x
+			}
+			x = &Field{x, p.text, safe}
+			p.next()
+		case p.text == "(":
+			switch x.(type) {
+			case *Ident, *Field:
+			default:
+				p.error(p.pos, fmt.Sprintf("can't call %s", x))
+			}
+			x = p.parseCall(x)
+		case p.text == "[":
+			p.next()
+			index := p.parseExpr()
+			p.expect(']')
+			x = &Index{x, index}
+		default:
+			return x
+		}
+	}
+}
+
+func (p *exprParser) parseCall(fun Expr) Expr {
+	call := &Call{Fun: fun, Lparen: p.pos}
+	p.next()
+	for p.tok != ')' && p.tok != TokEOF {
+		call.Args = append(call.Args, p.parseExpr())
+		if p.tok != TokComma {
+			break
+		}
+		p.next()
+	}
+	p.expect(')')
+	return call
+}
+
+// unquoteString returns the value of a single or double quoted string literal.
+func unquoteString(text string) (string, error) {
+	if len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+		// turn it into a double quoted literal for strconv
+		s := text[1:len(text)-1]
+		s = strings.Replace(s, "\\'", "'", -1)
+		s = strings.Replace(s, "\"", "\\\"", -1)
+		text = "\"" + s + "\""
+	}
+	return strconv.Unquote(text)
+}
@@ -0,0 +1,81 @@
+package mite
+
+import (
+	"strings"
+)
+
+// Filter turns the text of a filter block into the output for it. The text is
+// the lines nested under the :name line, as written in the template except for
+// their common indentation. The output is written as is.
+type Filter func(text string) (string, error)
+
+// FilterMap maps names to the filters that templates can use with :name lines.
+type FilterMap map[string]Filter
+
+// builtinFilters are available to every template. Filters added with
+// Template.Filters take precedence.
+var builtinFilters = FilterMap {
+	"plain":		filterPlain,
+	"cdata":		filterCDATA,
+	"css":			filterCSS,
+	"javascript":	filterJavaScript,
+	"markdown":		filterMarkdown,
+}
+
+// Filters adds the filters in filterMap to the template, replacing filters of
+// the same name, including built in ones. It returns the template so calls can
+// be chained.
+func (t *Template) Filters(filterMap FilterMap) *Template {
+	if t.filters == nil {
+		t.filters = make(FilterMap)
+	}
+	for name, f := range filterMap {
+		t.filters[name] = f
+	}
+	return t
+}
+
+// findFilter returns the filter called name in filters or the built in ones,
+// or nil if there is none.
+func findFilter(filters FilterMap, name string) Filter {
+	if f, found := filters[name]; found {
+		return f
+	}
+	return builtinFilters[name]
+}
+
+// filterOutput returns the output of the filter line n, with filters taking
+// precedence over the built in ones.
+func filterOutput(filters FilterMap, n *Node) (string, error) {
+	f := findFilter(filters, n.Filter)
+	if f == nil {
+		return "", posError(n.Pos, "filter %s not defined", n.Filter)
+	}
+	out, err := f(n.Text)
+	if err != nil {
+		return "", posError(n.Pos, "error in filter %s: %s", n.Filter, err)
+	}
+	return out, nil
+}
+
+func filterPlain(text string) (string, error) {
+	return text, nil
+}
+
+func filterCDATA(text string) (string, error) {
+	// ]]> would end the section early, so it is split over two sections
+	text = strings.Replace(text, "]]>", "]]]]><![CDATA[>", -1)
+	return "<![CDATA[" + text + "]]>", nil
+}
+
+func filterCSS(text string) (string, error) {
+	return "<style>" + text + "</style>", nil
+}
+
+func filterJavaScript(text string) (string, error) {
+	return "<script>" + text + "</script>", nil
+}
+
+func filterMarkdown(text string) (string, error) {
+	return markdown(text), nil
+}
@@ -0,0 +1,180 @@
+package mite
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// HTML is text that is known to be safe HTML. Values of type HTML, such as the
+// result of a function returning HTML, are written in text without being
+// escaped. In attribute values they are escaped like other values.
+type HTML string
+
+var htmlType = reflect.TypeOf(HTML(""))
+
+// FuncMap maps names to the functions that templates can call, like the
+// FuncMap of text/template. Each function must return one value, or a value
+// and an error. A non-nil error stops Execute.
+type FuncMap map[string]interface{}
+
+// Funcs adds the functions in funcMap to the template, replacing functions of
+// the same name. It panics if a value is not a suitable function. It returns
+// the template so calls can be chained.
+func (t *Template) Funcs(funcMap FuncMap) *Template {
+	if t.funcs == nil {
+		t.funcs = make(map[string]reflect.Value)
+	}
+	for name, fn := range funcMap {
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func {
+			panic(fmt.Sprintf("mite: value for %s is not a function", name))
+		}
+		if !goodFunc(v.Type()) {
+			panic(fmt.Sprintf("mite: function %s must return a value and an optional error", name))
+		}
+		t.funcs[name] = v
+	}
+	return t
+}
+
+// goodFunc reports whether a function or method of type t returns a value and
+// optionally an error.
+func goodFunc(t reflect.Type) bool {
+	switch {
+	case t.NumOut() == 1:
+		return true
+	case t.NumOut() == 2 && t.Out(1) == errorType:
+		return true
+	}
+	return false
+}
+
+// evalCall calls the function or method of e with its arguments.
+func (r *renderer) evalCall(e *Call) (reflect.Value, error) {
+	var fn reflect.Value
+	switch f := e.Fun.(type) {
+	case *Ident:
+		fn = r.funcs[f.Name]
+		if !fn.IsValid() {
+			return reflect.Value{}, evalError(e, "function %s not defined", f.Name)
+		}
+	case *Field:
+		x, err := r.eval(f.X)
+		if err != nil {
+			return x, err
+		}
+		if f.Safe && !indirect(x).IsValid() {
+			return reflect.Value{}, nil
+		}
+		fn = findMethod(x, f.Name)
+		if !fn.IsValid() {
+			return reflect.Value{}, evalError(e, "can't call %s: no method %s in type %s", e.Fun, f.Name, typeName(unwrap(x)))
+		}
+		if !goodFunc(fn.Type()) {
+			return reflect.Value{}, evalError(e, "can't call %s: method must return a value and an optional error", e.Fun)
+		}
+	default:
+		return reflect.Value{}, evalError(e, "can't call %s", e.Fun)
+	}
+
+	t := fn.Type()
+	if t.IsVariadic() && len(e.Args) < t.NumIn()-1 {
+		return reflect.Value{}, evalError(e, "wrong number of arguments for %s: want at least %d, got %d", e.Fun, t.NumIn()-1, len(e.Args))
+	}
+	if !t.IsVariadic() && len(e.Args) != t.NumIn() {
+		return reflect.Value{}, evalError(e, "wrong number of arguments for %s: want %d, got %d", e.Fun, t.NumIn(), len(e.Args))
+	}
+	args := make([]reflect.Value, len(e.Args))
+	for i, arg := range e.Args {
+		v, err := r.eval(arg)
+		if err != nil {
+			return v, err
+		}
+		var in reflect.Type
+		if t.IsVariadic() && i >= t.NumIn()-1 {
+			in = t.In(t.NumIn() - 1).Elem()
+		} else {
+			in = t.In(i)
+		}
+		if args[i], err = convertArg(v, in, arg); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	return safeCall(fn, args, e, e.Fun)
+}
+
+// safeCall calls fn with args, returning its error or a panic in it as an
+// error at e, like text/template does. name is what is called, for messages.
+func safeCall(fn reflect.Value, args []reflect.Value, e, name Expr) (v reflect.Value, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			v, err = reflect.Value{}, evalError(e, "error calling %s: %v", name, p)
+		}
+	}()
+	out := fn.Call(args)
+	if len(out) == 2 && !out[1].IsNil() {
+		return out[0], evalError(e, "error calling %s: %s", name, out[1].Interface())
+	}
+	return out[0], nil
+}
+
+// findMethod returns the method called name of v or of what it points to.
+// Only methods with pointer receivers are found on nil pointers, since value
+// methods can't be called on them.
+func findMethod(v reflect.Value, name string) reflect.Value {
+	for m := v; m.IsValid(); m = m.Elem() {
+		isPtr := m.Kind() == reflect.Ptr || m.Kind() == reflect.Interface
+		if isPtr && m.IsNil() {
+			if m.Kind() == reflect.Ptr {
+				if _, isValueMethod := m.Type().Elem().MethodByName(name); !isValueMethod {
+					return m.MethodByName(name)
+				}
+			}
+			break
+		}
+		if method := m.MethodByName(name); method.IsValid() {
+			return method
+		}
+		if !isPtr {
+			break
+		}
+	}
+	return reflect.Value{}
+}
+
+// convertArg converts v to the type of a function parameter. Numbers convert
+// to other number types as long as no fraction is lost, and nil to the zero
+// value of pointers, slices, maps and interfaces.
+func convertArg(v reflect.Value, t reflect.Type, e Expr) (reflect.Value, error) {
+	if !v.IsValid() {
+		switch t.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return reflect.Zero(t), nil
+		}
+		return v, evalError(e, "can't use nil %s as %s", e, t)
+	}
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	v = unwrap(v)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	switch {
+	case isNumber(v) && isInt(reflect.Zero(t)):
+		if !isInt(v) {
+			if _, ok := toInt(v); !ok {
+				return v, evalError(e, "can't use %s (%v) as %s without losing the fraction", e, v.Interface(), t)
+			}
+		}
+		return v.Convert(t), nil
+	case isNumber(v) && isNumber(reflect.Zero(t)):
+		return v.Convert(t), nil
+	case v.Kind() == reflect.String && t.Kind() == reflect.String:
+		return v.Convert(t), nil
+	case v.Kind() == reflect.Bool && t.Kind() == reflect.Bool:
+		return v.Convert(t), nil
+	}
+	return v, evalError(e, "can't use %s (type %s) as %s", e, v.Type(), t)
+}
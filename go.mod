@@ -0,0 +1,3 @@
+module github.com/glennyonemitsu/mite
+
+go 1.21
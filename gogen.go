@@ -0,0 +1,504 @@
+package mite
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// GenOptions configures the Go code written by Template.Generate.
+type GenOptions struct {
+	// Package is the name of the package of the generated file.
+	Package string
+
+	// Func is the name of the render function, such as RenderIndex.
+	Func string
+
+	// DataType is the type of the data parameter, such as *IndexData. Data
+	// fields are accessed as fields of it.
+	DataType string
+}
+
+// Generate returns the source of a Go file with a render function for the
+// template, which writes the same HTML as Execute without parsing or walking
+// the node tree:
+//
+//	func RenderIndex(w io.Writer, data *IndexData) error
+//
+// Expressions become Go expressions, so field accesses are checked by the Go
+// compiler. As Go doesn't know whether a name is a field or a method, methods
+// are called with parentheses, like user.FullName(), and maps are indexed with
+// brackets, like m["key"]. Functions called in the template must be in the
+// same package as the generated code, and return a single value. ?. is not
+// supported, and maps are ranged in Go's random order. Filters are applied
+// when the code is generated.
+func (t *Template) Generate(opts GenOptions) ([]byte, error) {
+	if err := checkVoid(t.Root, t.Mode); err != nil {
+		return nil, err
+	}
+	g := &codeGenerator{
+		sortAttrs: t.SortAttrs,
+		mode: t.Mode,
+		filters: t.filters,
+		used: make(map[string]bool),
+	}
+	for _, name := range []string{"w", "bw", "data", "mite", "bufio", "io"} {
+		g.used[name] = true
+	}
+	g.indent = 1
+	g.node(t.Root)
+	g.flush()
+	if err := g.errors.Err(); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	// absolute paths would make the output differ between machines
+	source := filepath.ToSlash(t.Name)
+	if filepath.IsAbs(t.Name) {
+		source = filepath.Base(t.Name)
+	}
+	if source == "" {
+		source = "a mite template"
+	}
+	fmt.Fprintf(&b, "// Code generated by mite gen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&b, "package %s\n\n", opts.Package)
+	b.WriteString("import (\n\t\"bufio\"\n\t\"io\"\n")
+	if g.usesMite {
+		b.WriteString("\n\t\"github.com/glennyonemitsu/mite\"\n")
+	}
+	b.WriteString(")\n\n")
+	fmt.Fprintf(&b, "// %s writes the HTML of %s for data to w.\n", opts.Func, source)
+	fmt.Fprintf(&b, "func %s(w io.Writer, data %s) error {\n", opts.Func, opts.DataType)
+	b.WriteString("\tbw := bufio.NewWriter(w)\n")
+	b.WriteString(g.b.String())
+	b.WriteString("\treturn bw.Flush()\n}\n")
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("mite: formatting generated code: %s", err)
+	}
+	return src, nil
+}
+
+// TextString returns v printed and escaped for HTML text, unless it is HTML.
+// It is used by generated code.
+func TextString(v interface{}) string {
+	if h, ok := v.(HTML); ok {
+		return string(h)
+	}
+	return escapeText(RawString(v))
+}
+
+// AttrString returns v printed and escaped for an attribute value, even if it
+// is HTML. It is used by generated code.
+func AttrString(v interface{}) string {
+	return escapeAttr(RawString(v))
+}
+
+// RawString returns v printed like Execute does, without escaping. It is used
+// by generated code.
+func RawString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case HTML:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return printValue(reflect.ValueOf(v))
+}
+
+// Truth reports whether v is true in a condition, with the same rules as if
+// lines. It is used by generated code.
+func Truth(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case int:
+		return v != 0
+	}
+	return truth(reflect.ValueOf(v))
+}
+
+// codeGenerator writes the body of a Go render function for a tree of Nodes.
+// Static output is collected and written with one WriteString call.
+type codeGenerator struct {
+	b strings.Builder
+	indent int
+
+	// static output not written yet
+	static string
+
+	sortAttrs bool
+	mode OutputMode
+	filters FilterMap
+
+	// mite variables in scope, innermost last, and the Go names taken so far
+	vars []codeVar
+	calls callScopes
+	used map[string]bool
+
+	// the code refers to the mite package
+	usesMite bool
+
+	errors ErrorList
+}
+
+type codeVar struct {
+	name string
+	goName string
+	// for loop, the Go variables of the counter and the collection
+	counter string
+	collection string
+	// set when the variable is used, so unused loop variables can be _
+	used bool
+}
+
+func (g *codeGenerator) line(format string, args ...interface{}) {
+	g.flush()
+	g.b.WriteString(strings.Repeat("\t", g.indent))
+	fmt.Fprintf(&g.b, format, args...)
+	g.b.WriteString("\n")
+}
+
+// write adds static output.
+func (g *codeGenerator) write(s string) {
+	g.static += s
+}
+
+// flush writes the static output collected so far.
+func (g *codeGenerator) flush() {
+	if g.static == "" {
+		return
+	}
+	s := g.static
+	g.static = ""
+	g.line("bw.WriteString(%s)", strconv.Quote(s))
+}
+
+// declare returns a new Go variable name for the mite variable name.
+func (g *codeGenerator) declare(name string) string {
+	goName := name
+	for i := 1; g.used[goName] || token.IsKeyword(goName); i++ {
+		goName = name + strconv.Itoa(i)
+	}
+	g.used[goName] = true
+	return goName
+}
+
+// define declares the Go variable goName with value, the Go expression of e.
+// A nil literal has no type in Go, so the variable gets interface{}.
+func (g *codeGenerator) define(goName string, e Expr, value string) {
+	for {
+		p, ok := e.(*Paren)
+		if !ok {
+			break
+		}
+		e = p.X
+	}
+	if l, ok := e.(*Literal); ok && l.Value == nil {
+		g.line("var %s interface{}", goName)
+	} else {
+		g.line("%s := %s", goName, value)
+	}
+	g.line("_ = %s", goName)
+}
+
+func (g *codeGenerator) lookupVar(name string) *codeVar {
+	for i := len(g.vars) - 1; i >= 0; i-- {
+		if g.vars[i].name == name && !g.calls.isHidden(i) {
+			return &g.vars[i]
+		}
+	}
+	return nil
+}
+
+func (g *codeGenerator) node(n *Node) {
+	switch n.Type {
+	case NodeRoot, NodeBlock:
+		if g.calls.isSlot(n) {
+			// the content of the call, which sees the caller's variables
+			g.calls.enterContent(len(g.vars))
+			defer g.calls.leaveContent()
+		}
+		g.children(n)
+	case NodeTag:
+		writeTag(g, n, g.sortAttrs, g.mode)
+	case NodeDoctype:
+		d := lookupDoctype(n.Text)
+		g.mode = d.mode
+		g.write(d.decl)
+	case NodeHTMLComment:
+		writeHTMLComment(g, n)
+	case NodeIf:
+		g.line("if %s {", g.condition(n.Expr))
+		g.block(n)
+		for c := n.Else; c != nil; c = c.Else {
+			if c.Type == NodeElse {
+				g.line("} else {")
+			} else {
+				g.line("} else if %s {", g.condition(c.Expr))
+			}
+			g.block(c)
+		}
+		g.line("}")
+	case NodeEach:
+		g.each(n)
+	case NodeAssign:
+		value := g.expr(n.Expr)
+		goName := g.declare(n.Var)
+		g.vars = append(g.vars, codeVar{name: n.Var, goName: goName})
+		g.define(goName, n.Expr, value)
+	case NodeCall:
+		// arguments first, as for renderer.renderCall
+		values := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			values[i] = g.expr(arg)
+		}
+		mark := len(g.vars)
+		g.calls.enter(mark)
+		for i, name := range n.Params {
+			goName := g.declare(name)
+			g.vars = append(g.vars, codeVar{name: name, goName: goName})
+			g.define(goName, n.Args[i], values[i])
+		}
+		g.children(n)
+		g.calls.leave()
+		g.vars = g.vars[:mark]
+	case NodeFilter:
+		out, err := filterOutput(g.filters, n)
+		if err != nil {
+			g.errors = append(g.errors, err.(ErrorList)...)
+			return
+		}
+		g.write(out)
+	case NodeText:
+		if n.Raw {
+			g.parts(n.Text, n.Parts, nil, "RawString")
+		} else {
+			g.parts(n.Text, n.Parts, escapeText, "TextString")
+		}
+	}
+}
+
+// block writes the children of n indented, as the body of a Go block.
+func (g *codeGenerator) block(n *Node) {
+	g.flush()
+	g.indent++
+	g.children(n)
+	g.flush()
+	g.indent--
+}
+
+// each writes a for range loop. The loop variable is a counter that is
+// written only if loop is used, or there is an else.
+func (g *codeGenerator) each(n *Node) {
+	collection := g.declare("collection")
+	counter := g.declare("index")
+	g.line("%s := %s", collection, g.expr(n.Expr))
+
+	mark := len(g.vars)
+	key := "_"
+	if n.Key != "" {
+		key = g.declare(n.Key)
+	}
+	elem := g.declare(n.Var)
+	g.vars = append(g.vars,
+		codeVar{name: n.Key, goName: key},
+		codeVar{name: n.Var, goName: elem},
+		codeVar{name: "loop", counter: counter, collection: collection})
+
+	// the body is written first to find out which variables are used
+	outer := g.b.String()
+	g.b.Reset()
+	g.block(n)
+	body := g.b.String()
+	g.b.Reset()
+	g.b.WriteString(outer)
+	vars := g.vars[mark:]
+	if !vars[0].used {
+		key = "_"
+	}
+	if !vars[1].used {
+		elem = "_"
+	}
+	loopUsed := vars[2].used
+	g.vars = g.vars[:mark]
+
+	counted := loopUsed || n.Else != nil
+	if counted {
+		g.line("%s := -1", counter)
+	}
+	switch {
+	case key == "_" && elem == "_":
+		g.line("for range %s {", collection)
+	case elem == "_":
+		g.line("for %s := range %s {", key, collection)
+	default:
+		g.line("for %s, %s := range %s {", key, elem, collection)
+	}
+	if counted {
+		g.indent++
+		g.line("%s++", counter)
+		g.indent--
+	}
+	g.b.WriteString(body)
+	g.line("}")
+	if n.Else != nil {
+		g.line("if %s == -1 {", counter)
+		g.block(n.Else)
+		g.line("}")
+	}
+}
+
+func (g *codeGenerator) children(n *Node) {
+	mark := len(g.vars)
+	writeChildren(g, n)
+	g.vars = g.vars[:mark]
+}
+
+func (g *codeGenerator) writeAttr(attr *Attr) {
+	if attr.Raw {
+		g.parts(attr.Value, attr.Parts, nil, "RawString")
+	} else {
+		g.parts(attr.Value, attr.Parts, escapeAttr, "AttrString")
+	}
+}
+
+// parts writes text, or its parts if it has interpolations, escaping the
+// literal text with escape unless it is nil. Values are printed with the
+// named function of this package.
+func (g *codeGenerator) parts(text string, parts []*Part, escape func(string) string, printer string) {
+	if parts == nil {
+		parts = []*Part{{Text: text}}
+	}
+	for _, part := range parts {
+		if part.Expr == nil {
+			if escape != nil {
+				g.write(escape(part.Text))
+			} else {
+				g.write(part.Text)
+			}
+			continue
+		}
+		g.usesMite = true
+		g.line("bw.WriteString(mite.%s(%s))", printer, g.expr(part.Expr))
+	}
+}
+
+// condition returns e as a Go bool expression.
+func (g *codeGenerator) condition(e Expr) string {
+	if b, ok := e.(*Binary); ok {
+		switch b.Op {
+		case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+			// comparisons and boolean operators are bool already
+			return g.expr(e)
+		}
+	}
+	if u, ok := e.(*Unary); ok && u.Op == "!" {
+		return g.expr(e)
+	}
+	g.usesMite = true
+	return "mite.Truth(" + g.expr(e) + ")"
+}
+
+// expr returns e as a Go expression.
+func (g *codeGenerator) expr(e Expr) string {
+	switch e := e.(type) {
+	case *Ident:
+		if v := g.lookupVar(e.Name); v != nil {
+			if v.name == "loop" {
+				g.errors.Add(e.Pos(), "loop can only be used as loop.Index, loop.First or loop.Last")
+				return "nil"
+			}
+			v.used = true
+			return v.goName
+		}
+		return "data." + e.Name
+	case *Field:
+		if x, ok := e.X.(*Ident); ok {
+			if v := g.lookupVar(x.Name); v != nil && v.name == "loop" {
+				return g.loopField(v, e)
+			}
+		}
+		if e.Safe {
+			g.errors.Add(e.Pos(), "?. is not supported in generated code")
+		}
+		return g.expr(e.X) + "." + e.Name
+	case *Index:
+		return g.expr(e.X) + "[" + g.expr(e.Index) + "]"
+	case *Literal:
+		if s, ok := e.Value.(string); ok {
+			return strconv.Quote(s)
+		}
+		return e.Text
+	case *Paren:
+		return "(" + g.expr(e.X) + ")"
+	case *callerExpr:
+		g.calls.enterContent(len(g.vars))
+		defer g.calls.leaveContent()
+		return g.expr(e.X)
+	case *Unary:
+		if e.Op == "!" {
+			return "!" + g.condition(e.X)
+		}
+		return "-" + g.expr(e.X)
+	case *Binary:
+		if e.Op == "&&" || e.Op == "||" {
+			return g.condition(e.X) + " " + e.Op + " " + g.condition(e.Y)
+		}
+		return g.expr(e.X) + " " + e.Op + " " + g.expr(e.Y)
+	case *Call:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = g.expr(arg)
+		}
+		var fun string
+		switch f := e.Fun.(type) {
+		case *Ident:
+			fun = f.Name
+		case *Field:
+			fun = g.expr(f)
+		}
+		return fun + "(" + strings.Join(args, ", ") + ")"
+	case *Pipe:
+		args := make([]string, 0, len(e.Args)+1)
+		for _, arg := range e.Args {
+			args = append(args, g.expr(arg))
+		}
+		args = append(args, g.expr(e.X))
+		return e.Fun.Name + "(" + strings.Join(args, ", ") + ")"
+	}
+	g.errors.Add(e.Pos(), "can't generate code for "+e.String())
+	return "nil"
+}
+
+// loopField returns the Go expression for loop.Index, loop.First or
+// loop.Last.
+func (g *codeGenerator) loopField(loop *codeVar, e *Field) string {
+	loop.used = true
+	switch e.Name {
+	case "Index":
+		return loop.counter
+	case "First":
+		return "(" + loop.counter + " == 0)"
+	case "Last":
+		return "(" + loop.counter + " == len(" + loop.collection + ")-1)"
+	}
+	g.errors.Add(e.Pos(), "loop has no field "+e.Name)
+	return "nil"
+}
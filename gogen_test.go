@@ -0,0 +1,149 @@
+package mite
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type stringer struct{ s string }
+
+func (s stringer) String() string { return s.s }
+
+func TestRawString(t *testing.T) {
+	tests := []struct {
+		v interface{}
+		want string
+	}{
+		{nil, ""},
+		{"a & b", "a & b"},
+		{HTML("<b>"), "<b>"},
+		{42, "42"},
+		{2.5, "2.5"},
+		{true, "true"},
+		{stringer{"s"}, "s"},
+		{&stringer{"p"}, "p"},
+		{(*stringer)(nil), ""},
+		{(*int)(nil), ""},
+	}
+	for _, test := range tests {
+		if got := RawString(test.v); got != test.want {
+			t.Errorf("RawString(%#v) = %q, want %q", test.v, got, test.want)
+		}
+	}
+}
+
+// the data of the generated code tests, declared in the package of the
+// generated code
+const genDataSource = `package main
+
+type Item struct {
+	Name string
+	N    int
+}
+
+type User struct {
+	Name string
+}
+
+func (u *User) Greeting() string { return "Hi " + u.Name }
+
+type Data struct {
+	Name  string
+	Count int
+	Price float64
+	Items []Item
+	User  *User
+	Bold  mite.HTML
+}
+
+func upper(s string) string { return strings.ToUpper(s) }
+
+var data = &Data{
+	Name:  "Tom & Jerry",
+	Count: 2,
+	Price: 2.5,
+	Items: []Item{{"a", 1}, {"b", 2}},
+	User:  &User{"Ann"},
+	Bold:  "<b>'x'</b>",
+}
+`
+
+var genTests = []struct {
+	name string
+	src string
+	want string
+}{
+	{"escape", "p title=#{Name} #{Name}", "<p title='Tom &amp; Jerry'>Tom &amp; Jerry</p>"},
+	{"html", "p title=#{Bold} #{Bold}", "<p title='&lt;b&gt;&#39;x&#39;&lt;/b&gt;'><b>'x'</b></p>"},
+	{"if", "if Count > 1 && User != nil\n  p many\nelse\n  p few", "<p>many</p>"},
+	{"each", "ul\n  each item in Items\n    li class=#{loop.Last} #{loop.Index}#{item.Name}", "<ul><li class='false'>0a</li><li class='true'>1b</li></ul>"},
+	{"assign", "- total = Price * 2\n- none = nil\np #{total} #{none == nil}", "<p>5 true</p>"},
+	{"method", "p #{User.Greeting()} #{upper(Name)}", "<p>Hi Ann TOM &amp; JERRY</p>"},
+	{"mixin", "- title = \"outer\"\nmixin card(title)\n  .card&attributes\n    h2 #{title}\n    block\n+card(\"inner\") data-t=#{title}\n  p #{title}", "<div class='card' data-t='outer'><h2>inner</h2><p>outer</p></div>"},
+	{"doctype", "doctype strict\nbr", "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Strict//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd\"><br/>"},
+}
+
+// TestGenerateBuild compiles the code generated for genTests with the go
+// command and checks what it writes.
+func TestGenerateBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a Go program")
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module gentest\n\ngo 1.21\n\nrequire github.com/glennyonemitsu/mite v0.0.0\n\nreplace github.com/glennyonemitsu/mite => " + root + "\n",
+		"data.go": strings.Replace(genDataSource, "package main\n", "package main\n\nimport (\n\t\"strings\"\n\n\t\"github.com/glennyonemitsu/mite\"\n)\n", 1),
+	}
+	var main strings.Builder
+	main.WriteString("package main\n\nimport \"os\"\n\nfunc main() {\n")
+	for i, test := range genTests {
+		tmpl, err := Compile(strings.NewReader(test.src))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		fn := "Render" + strconv.Itoa(i)
+		src, err := tmpl.Generate(GenOptions{Package: "main", Func: fn, DataType: "*Data"})
+		if err != nil {
+			t.Fatalf("%s: Generate: %s", test.name, err)
+		}
+		files[fn+".go"] = string(src)
+		fmt.Fprintf(&main, "\t%s(os.Stdout, data)\n\tos.Stdout.WriteString(\"\\n\")\n", fn)
+	}
+	main.WriteString("}\n")
+	files["main.go"] = main.String()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goCmd, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %s\n%s", err, out)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != len(genTests) {
+		t.Fatalf("got %d outputs, want %d:\n%s", len(lines), len(genTests), out)
+	}
+	for i, test := range genTests {
+		if lines[i] != test.want {
+			t.Errorf("%s: output %q, want %q", test.name, lines[i], test.want)
+		}
+	}
+}
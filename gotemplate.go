@@ -0,0 +1,470 @@
+package mite
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// TextTemplate returns the template as text/template source that produces the
+// same HTML. Data fields are looked up from $, so they are found inside range
+// blocks too. Unlike in mite, the arguments of functions are not converted to
+// the types of their parameters.
+//
+// The source needs GoTemplateFuncs, and the functions added with Funcs under
+// the same names, added to the Go template before it is parsed. Filters are
+// applied once, while generating the source.
+func (t *Template) TextTemplate() (string, error) {
+	return t.goTemplate(true)
+}
+
+// HTMLTemplate returns the template as html/template source. It is the same as
+// TextTemplate, except that values are left for the contextual escaping of
+// html/template. HTML values are passed on as html/template's HTML type, but
+// raw text and attributes are escaped, and HTML comments are removed.
+func (t *Template) HTMLTemplate() (string, error) {
+	return t.goTemplate(false)
+}
+
+func (t *Template) goTemplate(escape bool) (string, error) {
+	if err := checkVoid(t.Root, t.Mode); err != nil {
+		return "", err
+	}
+	g := &goGenerator{
+		escape: escape,
+		sortAttrs: t.SortAttrs,
+		mode: t.Mode,
+		filters: t.filters,
+		used: make(map[string]bool),
+	}
+	g.node(t.Root)
+	if err := g.errors.Err(); err != nil {
+		return "", err
+	}
+	return g.b.String(), nil
+}
+
+// GoTemplateFuncs returns the functions used by the source from TextTemplate
+// and HTMLTemplate for printing and escaping values the way mite does, for
+// the operators, which follow mite's rules rather than those of the Go
+// template builtins, and for nil and loop.Last. Pass it to the Funcs method of
+// the Go template before parsing the source.
+func GoTemplateFuncs() map[string]interface{} {
+	return map[string]interface{} {
+		"miteText":	goText,
+		"miteString":	goString,
+		"miteEscape":	goEscape,
+		"miteEscapeAttr":	goEscapeAttr,
+		"miteAdd":	goOperator("+"),
+		"miteSub":	goOperator("-"),
+		"miteMul":	goOperator("*"),
+		"miteDiv":	goOperator("/"),
+		"miteMod":	goOperator("%"),
+		"miteEq":	goOperator("=="),
+		"miteNe":	goOperator("!="),
+		"miteLt":	goOperator("<"),
+		"miteLe":	goOperator("<="),
+		"miteGt":	goOperator(">"),
+		"miteGe":	goOperator(">="),
+		"miteTruth":	goTruth,
+		"miteNeg":	goNegate,
+		"miteNil":	goNil,
+		"miteLast":	goLast,
+	}
+}
+
+// the functions of GoTemplateFuncs for the arithmetic and comparison
+// operators
+var goOperators = map[string]string {
+	"+":	"miteAdd",
+	"-":	"miteSub",
+	"*":	"miteMul",
+	"/":	"miteDiv",
+	"%":	"miteMod",
+	"==":	"miteEq",
+	"!=":	"miteNe",
+	"<":	"miteLt",
+	"<=":	"miteLe",
+	">":	"miteGt",
+	">=":	"miteGe",
+}
+
+// the Go template builtins for the boolean operators. they evaluate only the
+// operands needed, like mite, but return an operand rather than a bool, so
+// their result is passed to miteTruth
+var goBuiltins = map[string]string {
+	"&&":	"and",
+	"||":	"or",
+}
+
+// goText returns x printed like the renderer does. HTML is returned as the
+// HTML type of html/template so it isn't escaped there either.
+func goText(x interface{}) interface{} {
+	v := reflect.ValueOf(x)
+	if v.IsValid() && v.Type() == htmlType {
+		return htmltemplate.HTML(v.String())
+	}
+	return printValue(v)
+}
+
+// goString returns x printed like the renderer does, as a string even if it is
+// HTML, for attribute values in html/template.
+func goString(x interface{}) string {
+	return printValue(reflect.ValueOf(x))
+}
+
+// goEscape returns x printed and escaped for text, unless it is HTML.
+func goEscape(x interface{}) string {
+	v := reflect.ValueOf(x)
+	if v.IsValid() && v.Type() == htmlType {
+		return v.String()
+	}
+	return escapeText(printValue(v))
+}
+
+// goEscapeAttr returns x printed and escaped for an attribute value, even if
+// it is HTML.
+func goEscapeAttr(x interface{}) string {
+	return escapeAttr(printValue(reflect.ValueOf(x)))
+}
+
+func goOperator(op string) func(x, y interface{}) (interface{}, error) {
+	return func(x, y interface{}) (interface{}, error) {
+		e := &Binary{X: &Ident{Name: "x"}, Op: op, Y: &Ident{Name: "y"}}
+		var r renderer
+		r.push("x", reflect.ValueOf(x))
+		r.push("y", reflect.ValueOf(y))
+		v, err := r.evalBinary(e)
+		if err != nil {
+			return nil, errors.New(err.(ErrorList)[0].Msg)
+		}
+		return v.Interface(), nil
+	}
+}
+
+func goTruth(x interface{}) bool {
+	return truth(reflect.ValueOf(x))
+}
+
+func goNegate(x interface{}) (interface{}, error) {
+	e := &Unary{Op: "-", X: &Ident{Name: "x"}}
+	var r renderer
+	r.push("x", reflect.ValueOf(x))
+	v, err := r.evalUnary(e)
+	if err != nil {
+		return nil, errors.New(err.(ErrorList)[0].Msg)
+	}
+	return v.Interface(), nil
+}
+
+// goNil returns nil, for nil literals where Go templates don't allow nil, such
+// as in assignments.
+func goNil() interface{} {
+	return nil
+}
+
+// goLast reports whether i is the index of the last element of collection.
+func goLast(i int, collection interface{}) bool {
+	v := indirect(reflect.ValueOf(collection))
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return i == v.Len()-1
+	}
+	return false
+}
+
+// goGenerator writes the Go template source for a tree of Nodes. It follows
+// the renderer, writing the static parts of the output and actions for the
+// rest.
+type goGenerator struct {
+	b strings.Builder
+
+	// escape values like the renderer, for text/template
+	escape bool
+	sortAttrs bool
+	mode OutputMode
+	filters FilterMap
+
+	// mite variables in scope, innermost last, and the Go template variable
+	// names taken so far. every mite variable gets its own Go variable, so
+	// scoping is the same without relying on Go template blocks
+	vars []goVar
+	calls callScopes
+	used map[string]bool
+
+	errors ErrorList
+}
+
+type goVar struct {
+	name string
+	goName string
+	// for loop, the Go variable of the index and the collection
+	index string
+	collection string
+}
+
+// write writes static output, escaping {{ so it isn't taken as an action.
+func (g *goGenerator) write(s string) {
+	g.b.WriteString(strings.Replace(s, "{{", "{{\"{{\"}}", -1))
+}
+
+func (g *goGenerator) action(s string) {
+	g.b.WriteString("{{" + s + "}}")
+}
+
+// declare returns a new Go variable name for the mite variable name.
+func (g *goGenerator) declare(name string) string {
+	goName := "$" + name
+	for i := 1; g.used[goName]; i++ {
+		goName = "$" + name + strconv.Itoa(i)
+	}
+	g.used[goName] = true
+	return goName
+}
+
+func (g *goGenerator) lookupVar(name string) *goVar {
+	for i := len(g.vars) - 1; i >= 0; i-- {
+		if g.vars[i].name == name && !g.calls.isHidden(i) {
+			return &g.vars[i]
+		}
+	}
+	return nil
+}
+
+func (g *goGenerator) node(n *Node) {
+	switch n.Type {
+	case NodeRoot, NodeBlock:
+		if g.calls.isSlot(n) {
+			// the content of the call, which sees the caller's variables
+			g.calls.enterContent(len(g.vars))
+			defer g.calls.leaveContent()
+		}
+		g.children(n)
+	case NodeTag:
+		writeTag(g, n, g.sortAttrs, g.mode)
+	case NodeDoctype:
+		d := lookupDoctype(n.Text)
+		g.mode = d.mode
+		g.write(d.decl)
+	case NodeHTMLComment:
+		writeHTMLComment(g, n)
+	case NodeIf:
+		g.action("if " + g.expr(n.Expr))
+		g.children(n)
+		for c := n.Else; c != nil; c = c.Else {
+			if c.Type == NodeElse {
+				g.action("else")
+			} else {
+				g.action("else if " + g.expr(c.Expr))
+			}
+			g.children(c)
+		}
+		g.action("end")
+	case NodeEach:
+		collection := g.operand(n.Expr)
+		mark := len(g.vars)
+		var index string
+		if n.Key != "" {
+			index = g.declare(n.Key)
+			g.vars = append(g.vars, goVar{name: n.Key, goName: index})
+		} else {
+			// for loop
+			index = g.declare("_i")
+		}
+		elem := g.declare(n.Var)
+		g.vars = append(g.vars, goVar{name: n.Var, goName: elem})
+		g.vars = append(g.vars, goVar{name: "loop", index: index, collection: collection})
+		g.action("range " + index + ", " + elem + " := " + collection)
+		g.children(n)
+		g.vars = g.vars[:mark]
+		if n.Else != nil {
+			g.action("else")
+			g.children(n.Else)
+		}
+		g.action("end")
+	case NodeAssign:
+		value := g.expr(n.Expr)
+		goName := g.declare(n.Var)
+		g.vars = append(g.vars, goVar{name: n.Var, goName: goName})
+		g.action(goName + " := " + value)
+	case NodeCall:
+		// arguments first, as for renderer.renderCall
+		values := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			values[i] = g.expr(arg)
+		}
+		mark := len(g.vars)
+		g.calls.enter(mark)
+		for i, name := range n.Params {
+			goName := g.declare(name)
+			g.vars = append(g.vars, goVar{name: name, goName: goName})
+			g.action(goName + " := " + values[i])
+		}
+		g.children(n)
+		g.calls.leave()
+		g.vars = g.vars[:mark]
+	case NodeFilter:
+		out, err := filterOutput(g.filters, n)
+		if err != nil {
+			g.errors = append(g.errors, err.(ErrorList)...)
+			return
+		}
+		g.write(out)
+	case NodeText:
+		if n.Raw {
+			g.parts(n.Text, n.Parts, nil, "", "miteText")
+		} else {
+			g.parts(n.Text, n.Parts, escapeText, "miteEscape", "miteText")
+		}
+	}
+}
+
+func (g *goGenerator) children(n *Node) {
+	mark := len(g.vars)
+	writeChildren(g, n)
+	g.vars = g.vars[:mark]
+}
+
+func (g *goGenerator) writeAttr(attr *Attr) {
+	if attr.Raw {
+		g.parts(attr.Value, attr.Parts, nil, "", "miteString")
+	} else {
+		g.parts(attr.Value, attr.Parts, escapeAttr, "miteEscapeAttr", "miteString")
+	}
+}
+
+// parts writes text, or its parts if it has interpolations, escaping the
+// literal text with escape unless it is nil. For text/template, values are
+// escaped by the GoTemplateFuncs function escaper; otherwise they are printed
+// by printer and html/template escapes them.
+func (g *goGenerator) parts(text string, parts []*Part, escape func(string) string, escaper, printer string) {
+	if parts == nil {
+		parts = []*Part{{Text: text}}
+	}
+	for _, part := range parts {
+		if part.Expr == nil {
+			if escape != nil {
+				g.write(escape(part.Text))
+			} else {
+				g.write(part.Text)
+			}
+			continue
+		}
+		if g.escape && escaper != "" {
+			g.action(g.expr(part.Expr) + " | " + escaper)
+		} else {
+			g.action(g.expr(part.Expr) + " | " + printer)
+		}
+	}
+}
+
+// expr returns e as a Go template pipeline.
+func (g *goGenerator) expr(e Expr) string {
+	switch e := e.(type) {
+	case *Ident:
+		if v := g.lookupVar(e.Name); v != nil {
+			if v.name == "loop" {
+				g.errors.Add(e.Pos(), "loop can only be used as loop.Index, loop.First or loop.Last")
+				return "nil"
+			}
+			return v.goName
+		}
+		return "$." + e.Name
+	case *Field:
+		if x, ok := e.X.(*Ident); ok {
+			if v := g.lookupVar(x.Name); v != nil && v.name == "loop" {
+				return g.loopField(v, e)
+			}
+		}
+		x := g.operand(e.X)
+		if e.Safe {
+			// and stops at a nil X
+			return "and " + x + " " + g.field(x, e.Name)
+		}
+		return g.field(x, e.Name)
+	case *Index:
+		return "index " + g.operand(e.X) + " " + g.operand(e.Index)
+	case *Literal:
+		if s, ok := e.Value.(string); ok {
+			return strconv.Quote(s)
+		}
+		if e.Value == nil {
+			// nil is only allowed as an argument in Go templates
+			return "miteNil"
+		}
+		return e.Text
+	case *Paren:
+		return g.expr(e.X)
+	case *callerExpr:
+		g.calls.enterContent(len(g.vars))
+		defer g.calls.leaveContent()
+		return g.expr(e.X)
+	case *Unary:
+		if e.Op == "!" {
+			return "not " + g.operand(e.X)
+		}
+		return "miteNeg " + g.operand(e.X)
+	case *Binary:
+		if fn := goBuiltins[e.Op]; fn != "" {
+			return "miteTruth (" + fn + " " + g.operand(e.X) + " " + g.operand(e.Y) + ")"
+		}
+		return goOperators[e.Op] + " " + g.operand(e.X) + " " + g.operand(e.Y)
+	case *Call:
+		var s string
+		switch fun := e.Fun.(type) {
+		case *Ident:
+			s = fun.Name
+		case *Field:
+			s = g.field(g.operand(fun.X), fun.Name)
+		}
+		for _, arg := range e.Args {
+			s += " " + g.operand(arg)
+		}
+		return s
+	case *Pipe:
+		s := g.expr(e.X) + " | " + e.Fun.Name
+		for _, arg := range e.Args {
+			s += " " + g.operand(arg)
+		}
+		return s
+	}
+	g.errors.Add(e.Pos(), "can't translate "+e.String())
+	return "nil"
+}
+
+// operand returns e as a Go template operand, which is its pipeline in
+// parentheses unless it is a single value.
+func (g *goGenerator) operand(e Expr) string {
+	s := g.expr(e)
+	if strings.ContainsAny(s, " |") && !isGoLiteral(e) {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func isGoLiteral(e Expr) bool {
+	_, ok := e.(*Literal)
+	return ok
+}
+
+// field returns the field or method name of the operand x.
+func (g *goGenerator) field(x, name string) string {
+	return x + "." + name
+}
+
+// loopField returns the Go template equivalent of loop.Index, loop.First or
+// loop.Last.
+func (g *goGenerator) loopField(loop *goVar, e *Field) string {
+	switch e.Name {
+	case "Index":
+		return loop.index
+	case "First":
+		return "eq " + loop.index + " 0"
+	case "Last":
+		return "miteLast " + loop.index + " " + loop.collection
+	}
+	g.errors.Add(e.Pos(), "loop has no field "+e.Name)
+	return "nil"
+}
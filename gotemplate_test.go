@@ -0,0 +1,101 @@
+package mite
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+)
+
+// data for the Go template tests, decoded from JSON like the data of the
+// mite command, so numbers are float64
+const goTemplateData = `{
+	"count": 1,
+	"price": 2.5,
+	"name": "Tom & Jerry",
+	"empty": "",
+	"items": [{"name": "a", "n": 1}, {"name": "b", "n": 2}],
+	"user": null
+}`
+
+var goTemplateTests = []struct {
+	name string
+	src string
+	// the html/template output is the same as mite's
+	html bool
+}{
+	{"and", "p #{name && count}", true},
+	{"or", "p #{empty || count}", true},
+	{"and false", "p #{empty && name}", true},
+	{"short circuit", "if user && user.name\n  p #{user.name}\nelse\n  p nobody", true},
+	{"not", "p #{!empty}", true},
+	{"int float equal", "if count == 1\n  p one", true},
+	{"int float order", "p #{count < price} #{price >= 2} #{count != 1.0}", true},
+	{"nil equal", "p #{user == nil}", true},
+	{"arithmetic", "p #{count + price} #{price * 2} #{-count}", true},
+	{"strings", "p #{name + \"!\"} #{name < \"Z\"}", false},
+	{"each", "ul\n  each item in items\n    li class=#{loop.Last} #{item.name == \"b\"} #{item.n * 10}", false},
+	{"assign", "- total = count + price\np #{total > 3}", true},
+	{"nil", "- x = nil\np #{x} #{x == nil} #{nil}\nif user == nil\n  p none", true},
+	{"html text", "p #{bold}", true},
+	{"html attribute", "p title=#{bold} x", true},
+	{"mixin attributes", "- title = name\nmixin card(title)\n  p&attributes #{title}\n    +inner&attributes\nmixin inner\n  span&attributes\n+card(\"inner\") data-t=#{title}", true},
+}
+
+func TestGoTemplateOutput(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(goTemplateData), &data); err != nil {
+		t.Fatal(err)
+	}
+	data.(map[string]interface{})["bold"] = HTML("<b>'x'</b>")
+	for _, test := range goTemplateTests {
+		tmpl, err := Compile(strings.NewReader(test.src))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var want bytes.Buffer
+		if err := tmpl.Execute(&want, data); err != nil {
+			t.Errorf("%s: Execute: %s", test.name, err)
+			continue
+		}
+
+		src, err := tmpl.TextTemplate()
+		if err != nil {
+			t.Errorf("%s: TextTemplate: %s", test.name, err)
+			continue
+		}
+		text, err := texttemplate.New(test.name).Funcs(GoTemplateFuncs()).Parse(src)
+		if err != nil {
+			t.Errorf("%s: parsing %q: %s", test.name, src, err)
+			continue
+		}
+		var got bytes.Buffer
+		if err := text.Execute(&got, data); err != nil {
+			t.Errorf("%s: text/template: %s", test.name, err)
+		} else if got.String() != want.String() {
+			t.Errorf("%s: text/template output %q, want %q", test.name, got.String(), want.String())
+		}
+
+		if !test.html {
+			continue
+		}
+		if src, err = tmpl.HTMLTemplate(); err != nil {
+			t.Errorf("%s: HTMLTemplate: %s", test.name, err)
+			continue
+		}
+		html, err := htmltemplate.New(test.name).Funcs(GoTemplateFuncs()).Parse(src)
+		if err != nil {
+			t.Errorf("%s: parsing %q: %s", test.name, src, err)
+			continue
+		}
+		got.Reset()
+		if err := html.Execute(&got, data); err != nil {
+			t.Errorf("%s: html/template: %s", test.name, err)
+		} else if got.String() != want.String() {
+			t.Errorf("%s: html/template output %q, want %q", test.name, got.String(), want.String())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package mite
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Part is a piece of text or of an attribute value. It is either literal Text
+// or an Expr interpolated with #{expr}.
+type Part struct {
+	Pos Position
+	Text string
+	Expr Expr
+}
+
+// parseInterp splits text into literal and interpolated parts. pos is the
+// position of text in the template. A backslash before #{ keeps it as literal
+// text. If text has no interpolations nil is returned.
+func parseInterp(text string, pos Position) ([]*Part, error) {
+	if !strings.Contains(text, "#{") {
+		return nil, nil
+	}
+
+	var parts []*Part
+	var errs ErrorList
+	literal := ""
+	literalPos := pos
+	for i := 0; i < len(text); {
+		if strings.HasPrefix(text[i:], "\\#{") {
+			literal += "#{"
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(text[i:], "#{") {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			literal += text[i:i+size]
+			i += size
+			continue
+		}
+
+		exprPos := advance(pos, text[:i+2])
+		end := interpEnd(text[i+2:])
+		if end < 0 {
+			errs.Add(advance(pos, text[:i]), "interpolation not terminated")
+			break
+		}
+		if literal != "" {
+			parts = append(parts, &Part{Pos: literalPos, Text: literal})
+			literal = ""
+		}
+		src := text[i+2:i+2+end]
+		expr, err := ParseExpr(src, exprPos)
+		if err != nil {
+			errs = append(errs, err.(ErrorList)...)
+		}
+		parts = append(parts, &Part{Pos: exprPos, Text: "#{" + src + "}", Expr: expr})
+		i += 2 + end + 1
+		literalPos = advance(pos, text[:i])
+	}
+	if literal != "" {
+		parts = append(parts, &Part{Pos: literalPos, Text: literal})
+	}
+	return parts, errs.Err()
+}
+
+// interpEnd returns the index of the } closing an interpolation in s, which
+// starts right after the #{. Braces and quotes within the expression are
+// skipped. It returns -1 if there is no closing brace.
+func interpEnd(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '{':
+			depth++
+		case ch == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// advance returns pos moved past text, which is on the same line.
+func advance(pos Position, text string) Position {
+	pos.Offset += len(text)
+	pos.Column += utf8.RuneCountInString(text)
+	return pos
+}
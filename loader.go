@@ -0,0 +1,238 @@
+package mite
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// loader parses templates from a file system, replaces their include lines
+// with the templates they name, and fills the blocks of the layouts they
+// extend.
+type loader struct {
+	fsys fs.FS
+
+	// directory of fsys in the OS file system, used for the filenames in
+	// positions. empty if fsys is not a directory
+	dir string
+
+	// names of the templates being loaded, outermost first, to find cycles
+	stack []string
+}
+
+// filename returns the name of a template in fsys as it is shown in positions.
+func (l *loader) filename(name string) string {
+	if l.dir == "" {
+		return name
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(name))
+}
+
+// loadFile opens and loads the template called name in fsys.
+func (l *loader) loadFile(name string) (*Node, error) {
+	if !fs.ValidPath(name) {
+		return nil, errors.New(name + " is outside the directory templates are read from")
+	}
+	f, err := l.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return l.load(name, f)
+}
+
+// load parses the template called name from src, and loads its includes and
+// the layout it extends. For a template that extends another, the tree
+// returned is the layout's, with its blocks filled.
+func (l *loader) load(name string, src io.Reader) (*Node, error) {
+	var p Parser
+	p.Scanner.Init(src)
+	p.Scanner.Filename = l.filename(name)
+	root, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	// includes are found first, since loading them changes the tree
+	var includes []*Node
+	Walk(root, func(n *Node) bool {
+		if n.Type == NodeInclude {
+			includes = append(includes, n)
+		}
+		return true
+	})
+	l.stack = append(l.stack, name)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+	var errors ErrorList
+	for _, n := range includes {
+		if err := l.include(n, name); err != nil {
+			if list, ok := err.(ErrorList); ok {
+				errors = append(errors, list...)
+			} else {
+				errors.Add(n.Pos, err.Error())
+			}
+		}
+	}
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	if ext := extendsNode(root); ext != nil {
+		if root, err = l.extend(root, ext, name); err != nil {
+			return nil, err
+		}
+	} else {
+		Walk(root, func(n *Node) bool {
+			if n.Type == NodeAppend || n.Type == NodePrepend {
+				errors.Add(n.Pos, strings.ToLower(n.TypeString())+" in a template that doesn't extend another")
+			}
+			return true
+		})
+		if len(errors) > 0 {
+			return nil, errors
+		}
+	}
+
+	// mixins can be defined in includes and layouts, so they are expanded
+	// once the outermost template is loaded
+	if len(l.stack) == 1 {
+		if err := expandMixins(root); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// include loads the template of the include node n, in the template from, and
+// puts its nodes in the place of n.
+func (l *loader) include(n *Node, from string) error {
+	name := includeName(from, n.Text)
+	if err := l.cycle(n.Pos, name, "includes"); err != nil {
+		return err
+	}
+	root, err := l.loadFile(name)
+	if err != nil {
+		if _, ok := err.(ErrorList); ok {
+			return err
+		}
+		return ErrorList{&Error{n.Pos, "can't include " + n.Text + ": " + err.Error()}}
+	}
+	splice(n, root.Children)
+	return nil
+}
+
+// extend loads the layout named by the extends node ext of the template from,
+// whose tree is root, and fills the layout's blocks with the block, append and
+// prepend nodes at the top level of root. Mixins defined at the top level of
+// root are moved to the layout.
+func (l *loader) extend(root, ext *Node, from string) (*Node, error) {
+	name := includeName(from, ext.Text)
+	if err := l.cycle(ext.Pos, name, "extends"); err != nil {
+		return nil, err
+	}
+	layout, err := l.loadFile(name)
+	if err != nil {
+		if _, ok := err.(ErrorList); ok {
+			return nil, err
+		}
+		return nil, ErrorList{&Error{ext.Pos, "can't extend " + ext.Text + ": " + err.Error()}}
+	}
+
+	var errors ErrorList
+	for _, n := range root.Children {
+		switch n.Type {
+		case NodeExtends, NodeComment:
+		case NodeMixin:
+			// mixins render nothing where they are defined
+			layout.AppendChild(n)
+		case NodeBlock, NodeAppend, NodePrepend:
+			// blocks are looked up each time, since filling one can
+			// replace others nested in it
+			block := findBlock(layout, n.Text)
+			if block == nil {
+				errors.Add(n.Pos, "block "+n.Text+" not found in "+ext.Text)
+				continue
+			}
+			for _, c := range n.Children {
+				c.Parent = block
+			}
+			switch n.Type {
+			case NodeBlock:
+				block.Children = n.Children
+			case NodeAppend:
+				block.Children = append(block.Children, n.Children...)
+			case NodePrepend:
+				block.Children = append(n.Children, block.Children...)
+			}
+		default:
+			errors.Add(n.Pos, "only blocks and mixins can be at the top level of a template that extends another")
+		}
+	}
+	return layout, errors.Err()
+}
+
+// cycle returns an error at pos if loading the template called name would
+// load a template already being loaded. verb joins the names in the message.
+func (l *loader) cycle(pos Position, name, verb string) error {
+	for i, s := range l.stack {
+		if s == name {
+			chain := append(l.stack[i:len(l.stack):len(l.stack)], name)
+			return ErrorList{&Error{pos, "template cycle: " + strings.Join(chain, " "+verb+" ")}}
+		}
+	}
+	return nil
+}
+
+// extendsNode returns the extends node of the template root, or nil if it
+// doesn't extend another.
+func extendsNode(root *Node) *Node {
+	for _, n := range root.Children {
+		if n.Type == NodeExtends {
+			return n
+		}
+	}
+	return nil
+}
+
+// findBlock returns the first block node called name in the tree n.
+func findBlock(n *Node, name string) *Node {
+	var block *Node
+	Walk(n, func(n *Node) bool {
+		if block == nil && n.Type == NodeBlock && n.Text == name {
+			block = n
+		}
+		return block == nil
+	})
+	return block
+}
+
+// includeName returns the name in the file system of the template included as
+// name by the template from. Names without an extension get .mite.
+func includeName(from, name string) string {
+	if path.Ext(name) == "" {
+		name += ".mite"
+	}
+	if strings.HasPrefix(name, "/") {
+		return path.Clean(name[1:])
+	}
+	return path.Join(path.Dir(from), name)
+}
+
+// splice replaces n among its parent's children with nodes.
+func splice(n *Node, nodes []*Node) {
+	parent := n.Parent
+	var children []*Node
+	for _, c := range parent.Children {
+		if c != n {
+			children = append(children, c)
+			continue
+		}
+		for _, inc := range nodes {
+			inc.Parent = parent
+			children = append(children, inc)
+		}
+	}
+	parent.Children = children
+}
@@ -0,0 +1,111 @@
+package mite
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+// files makes a file system of mite templates from names and sources.
+func files(nameSrc ...string) fstest.MapFS {
+	fsys := make(fstest.MapFS)
+	for i := 0; i < len(nameSrc); i += 2 {
+		fsys[nameSrc[i]] = &fstest.MapFile{Data: []byte(nameSrc[i+1])}
+	}
+	return fsys
+}
+
+var loaderTests = []struct {
+	name string
+	files fstest.MapFS
+	want string
+	err string
+}{
+	{
+		name: "include",
+		files: files("main.mite", "div\n  include part", "part.mite", "p part"),
+		want: "<div><p>part</p></div>",
+	},
+	{
+		name: "include relative",
+		files: files("main.mite", "include sub/a", "sub/a.mite", "include b", "sub/b.mite", "p b"),
+		want: "<p>b</p>",
+	},
+	{
+		name: "include cycle",
+		files: files("main.mite", "include a", "a.mite", "include b", "b.mite", "include a"),
+		err: "b.mite:1:1: template cycle: a.mite includes b.mite includes a.mite",
+	},
+	{
+		name: "include itself",
+		files: files("main.mite", "include main"),
+		err: "main.mite:1:1: template cycle: main.mite includes main.mite",
+	},
+	{
+		name: "include missing",
+		files: files("main.mite", "include missing"),
+		err: "main.mite:1:1: can't include missing: open missing.mite: file does not exist",
+	},
+	{
+		name: "include outside",
+		files: files("main.mite", "include ../x"),
+		err: "main.mite:1:1: can't include ../x: ../x.mite is outside the directory templates are read from",
+	},
+	{
+		name: "extends",
+		files: files(
+			"main.mite", "extends layout\nblock title\n  span x\nappend scripts\n  script b.js\nprepend scripts\n  script a.js",
+			"layout.mite", "title\n  block title\n    span default\nblock scripts\n  script main.js\nfooter\n  block foot\n    p f",
+		),
+		want: "<title><span>x</span></title><script>a.js</script><script>main.js</script><script>b.js</script><footer><p>f</p></footer>",
+	},
+	{
+		name: "extends chain",
+		files: files(
+			"main.mite", "extends mid\nblock inner\n  p child",
+			"mid.mite", "extends base\nblock body\n  div\n    block inner\n      p mid",
+			"base.mite", "main\n  block body",
+		),
+		want: "<main><div><p>child</p></div></main>",
+	},
+	{
+		name: "extends cycle",
+		files: files("main.mite", "extends a", "a.mite", "extends b", "b.mite", "extends a"),
+		err: "b.mite:1:1: template cycle: a.mite extends b.mite extends a.mite",
+	},
+	{
+		name: "unknown block",
+		files: files("main.mite", "extends layout\nblock nosuch\n  p", "layout.mite", "block body"),
+		err: "main.mite:2:1: block nosuch not found in layout",
+	},
+	{
+		name: "append without extends",
+		files: files("main.mite", "append scripts\n  p"),
+		err: "main.mite:1:1: append in a template that doesn't extend another",
+	},
+	{
+		name: "content outside blocks",
+		files: files("main.mite", "extends layout\np stray", "layout.mite", "block body"),
+		err: "main.mite:2:1: only blocks and mixins can be at the top level of a template that extends another",
+	},
+}
+
+func TestLoader(t *testing.T) {
+	for _, test := range loaderTests {
+		var b bytes.Buffer
+		tmpl, err := CompileFS(test.files, "main.mite")
+		if err == nil {
+			err = tmpl.Execute(&b, nil)
+		}
+		switch {
+		case test.err != "":
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case b.String() != test.want:
+			t.Errorf("%s: output %q, want %q", test.name, b.String(), test.want)
+		}
+	}
+}
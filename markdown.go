@@ -0,0 +1,174 @@
+package mite
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// markdown converts the common subset of Markdown to HTML: paragraphs,
+// # headings, lists, > quotes, code blocks, horizontal rules, and inline
+// `code`, **strong**, *emphasis* and [links](url). Links to javascript:,
+// vbscript: and data: URLs are left as text.
+func markdown(text string) string {
+	var b strings.Builder
+	writeMarkdownBlocks(&b, strings.Split(text, "\n"))
+	return b.String()
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdRule = regexp.MustCompile(`^(\*\s*){3,}$|^(-\s*){3,}$|^(_\s*){3,}$`)
+	mdBullet = regexp.MustCompile(`^[-*+]\s+`)
+	mdNumber = regexp.MustCompile(`^\d+[.)]\s+`)
+	mdLink = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdEmphasis = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+func writeMarkdownBlocks(b *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			i++
+		case strings.HasPrefix(trimmed, "```"):
+			i++
+			start := i
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+				i++
+			}
+			writeMarkdownCode(b, lines[start:i])
+			i++
+		case strings.HasPrefix(line, "    "):
+			var code []string
+			for ; i < len(lines) && (strings.HasPrefix(lines[i], "    ") || strings.TrimSpace(lines[i]) == ""); i++ {
+				code = append(code, strings.TrimPrefix(lines[i], "    "))
+			}
+			for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			writeMarkdownCode(b, code)
+		case mdHeading.MatchString(trimmed):
+			m := mdHeading.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + markdownInline(m[2]) + "</h" + level + ">")
+			i++
+		case mdRule.MatchString(trimmed):
+			b.WriteString("<hr>")
+			i++
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				l := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(l, " "))
+			}
+			b.WriteString("<blockquote>")
+			writeMarkdownBlocks(b, quote)
+			b.WriteString("</blockquote>")
+		case mdBullet.MatchString(trimmed):
+			i = writeMarkdownList(b, lines, i, "ul", mdBullet)
+		case mdNumber.MatchString(trimmed):
+			i = writeMarkdownList(b, lines, i, "ol", mdNumber)
+		default:
+			var para []string
+			for ; i < len(lines) && !endsParagraph(lines[i]); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			b.WriteString("<p>" + markdownInline(strings.Join(para, "\n")) + "</p>")
+		}
+	}
+}
+
+// endsParagraph reports whether line is blank or starts another block.
+func endsParagraph(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" ||
+		strings.HasPrefix(trimmed, "```") ||
+		strings.HasPrefix(trimmed, ">") ||
+		mdHeading.MatchString(trimmed) ||
+		mdRule.MatchString(trimmed) ||
+		mdBullet.MatchString(trimmed) ||
+		mdNumber.MatchString(trimmed)
+}
+
+// writeMarkdownList writes the list starting at lines[i] and returns the index
+// of the line after it. Indented lines continue the previous item.
+func writeMarkdownList(b *strings.Builder, lines []string, i int, tag string, marker *regexp.Regexp) int {
+	var items []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if m := marker.FindString(trimmed); m != "" {
+			items = append(items, trimmed[len(m):])
+		} else if trimmed != "" && strings.HasPrefix(lines[i], " ") && !endsParagraph(lines[i]) {
+			items[len(items)-1] += "\n" + trimmed
+		} else {
+			break
+		}
+	}
+	b.WriteString("<" + tag + ">")
+	for _, item := range items {
+		b.WriteString("<li>" + markdownInline(item) + "</li>")
+	}
+	b.WriteString("</" + tag + ">")
+	return i
+}
+
+func writeMarkdownCode(b *strings.Builder, lines []string) {
+	b.WriteString("<pre><code>")
+	b.WriteString(html.EscapeString(strings.Join(lines, "\n")))
+	b.WriteString("</code></pre>")
+}
+
+// markdownInline converts the inline markup of text, which is escaped.
+func markdownInline(text string) string {
+	var b strings.Builder
+	// odd pieces are `code`
+	pieces := strings.Split(text, "`")
+	if len(pieces)%2 == 0 {
+		// unmatched backtick, which is just text
+		pieces[len(pieces)-2] += "`" + pieces[len(pieces)-1]
+		pieces = pieces[:len(pieces)-1]
+	}
+	for i, piece := range pieces {
+		if i%2 == 1 {
+			b.WriteString("<code>" + html.EscapeString(piece) + "</code>")
+			continue
+		}
+		// link targets are kept out of the emphasis replacements
+		last := 0
+		for _, m := range mdLink.FindAllStringSubmatchIndex(piece, -1) {
+			b.WriteString(markdownEmphasis(piece[last:m[0]]))
+			label, target := piece[m[2]:m[3]], piece[m[4]:m[5]]
+			if isSafeURL(target) {
+				b.WriteString("<a href='" + html.EscapeString(target) + "'>" + markdownEmphasis(label) + "</a>")
+			} else {
+				b.WriteString(markdownEmphasis(label))
+			}
+			last = m[1]
+		}
+		b.WriteString(markdownEmphasis(piece[last:]))
+	}
+	return b.String()
+}
+
+// markdownEmphasis escapes text and converts its **strong** and *emphasis*.
+func markdownEmphasis(text string) string {
+	s := html.EscapeString(text)
+	s = mdStrong.ReplaceAllString(s, "<strong>$1$2</strong>")
+	return mdEmphasis.ReplaceAllString(s, "<em>$1$2</em>")
+}
+
+// isSafeURL reports whether a link target can't run script when followed.
+// Links with javascript:, vbscript: and data: targets are written as text.
+func isSafeURL(url string) bool {
+	url = strings.ToLower(strings.TrimSpace(url))
+	for _, scheme := range []string{"javascript:", "vbscript:", "data:"} {
+		if strings.HasPrefix(url, scheme) {
+			return false
+		}
+	}
+	return true
+}
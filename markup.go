@@ -0,0 +1,95 @@
+package mite
+
+// markupWriter is implemented by the walkers of the node tree: the renderer,
+// which writes HTML, and the Go template and Go code generators, which write
+// source that produces the same HTML. The markup rules they share are the
+// functions below, so the walkers only differ in how they write values.
+type markupWriter interface {
+	// write writes static output
+	write(s string)
+	// writeAttr writes the value of attr, escaped unless it is raw
+	writeAttr(attr *Attr)
+	node(n *Node)
+	// children writes the children of n with writeChildren. Variables
+	// assigned by them go out of scope at the end
+	children(n *Node)
+}
+
+// writeTag writes the tag n with its attributes and children.
+func writeTag(w markupWriter, n *Node, sortAttrs bool, mode OutputMode) {
+	w.write("<" + n.Tag)
+	attrs := n.Attrs
+	if sortAttrs {
+		attrs = sortedAttrs(attrs)
+	}
+	for _, attr := range attrs {
+		w.write(" " + attr.Name + "='")
+		w.writeAttr(attr)
+		w.write("'")
+	}
+	if isEmptyElement(n) {
+		if mode == ModeHTML && !n.SelfClosing {
+			w.write(">")
+		} else {
+			w.write("/>")
+		}
+		return
+	}
+	w.write(">")
+	w.children(n)
+	w.write("</" + n.Tag + ">")
+}
+
+// checkVoid reports the void elements with content in the tree n, which are
+// errors unless they are written in XML mode. mode is the output mode until a
+// doctype selects another.
+func checkVoid(n *Node, mode OutputMode) error {
+	var errors ErrorList
+	Walk(n, func(n *Node) bool {
+		switch {
+		case n.Type == NodeDoctype:
+			mode = lookupDoctype(n.Text).mode
+		case n.Type == NodeTag && mode != ModeXML && isVoidElement(n.Tag) && len(n.Children) > 0:
+			errors.Add(n.Children[0].Pos, "void element "+n.Tag+" cannot have content")
+		}
+		return true
+	})
+	return errors.Err()
+}
+
+// isEmptyElement reports whether the tag n is written without an end tag.
+func isEmptyElement(n *Node) bool {
+	return len(n.Children) == 0 && (n.SelfClosing || isVoidElement(n.Tag))
+}
+
+// writeHTMLComment writes the HTML comment n, with its children inside it.
+func writeHTMLComment(w markupWriter, n *Node) {
+	w.write("<!--")
+	if n.Text != "" {
+		w.write(" " + n.Text)
+	}
+	if len(n.Children) > 0 {
+		w.write(" ")
+		w.children(n)
+	}
+	w.write(" -->")
+}
+
+// writeChildren writes the children of n, leaving out template comments.
+func writeChildren(w markupWriter, n *Node) {
+	var prev *Node
+	for _, c := range n.Children {
+		if c.Type == NodeComment {
+			continue
+		}
+		// text on consecutive lines is separated the same as a line break
+		// within a text node
+		if prev != nil && c.Type == NodeText && prev.Type == NodeText {
+			w.write(" ")
+		}
+		w.node(c)
+		if c.Type != NodeAssign {
+			prev = c
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package mite
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mixinExpander replaces mixin calls with the bodies of the mixins they call.
+type mixinExpander struct {
+	mixins map[string]*Node
+
+	// calls already expanded, which end up in the tree again as the content
+	// of the calls they are nested under
+	expanded map[*Node]bool
+
+	errors ErrorList
+}
+
+// expandMixins removes the mixin definitions from the tree root and expands
+// the calls of them. Expanded calls keep their arguments, and their children
+// become the body of the mixin, with the lines nested under the call in
+// place of its bare block lines and the attributes of the call added to its
+// &attributes tags.
+func expandMixins(root *Node) error {
+	e := &mixinExpander{
+		mixins: make(map[string]*Node),
+		expanded: make(map[*Node]bool),
+	}
+	var defs []*Node
+	Walk(root, func(n *Node) bool {
+		if n.Type != NodeMixin {
+			return true
+		}
+		if prev := e.mixins[n.Text]; prev != nil {
+			e.errors.Add(n.Pos, "mixin "+n.Text+" already defined at "+prev.Pos.String())
+		} else {
+			e.mixins[n.Text] = n
+		}
+		defs = append(defs, n)
+		return false
+	})
+	for _, n := range defs {
+		splice(n, nil)
+	}
+	e.expandAll(root, nil)
+	return e.errors.Err()
+}
+
+// expandAll expands the calls in the tree n. stack has the names of the
+// mixins whose bodies n is in, to find mixins that call themselves.
+func (e *mixinExpander) expandAll(n *Node, stack []string) {
+	Walk(n, func(n *Node) bool {
+		if n.Type != NodeCall || e.expanded[n] {
+			return true
+		}
+		e.expand(n, stack)
+		return false
+	})
+}
+
+func (e *mixinExpander) expand(call *Node, stack []string) {
+	e.expanded[call] = true
+	// the content of the call is part of the caller, not of the mixin
+	for _, c := range call.Children {
+		e.expandAll(c, stack)
+	}
+
+	mixin := e.mixins[call.Text]
+	if mixin == nil {
+		e.errors.Add(call.Pos, "mixin "+call.Text+" not defined")
+		return
+	}
+	for i, name := range stack {
+		if name == call.Text {
+			chain := append(stack[i:len(stack):len(stack)], name)
+			e.errors.Add(call.Pos, "mixin cycle: "+strings.Join(chain, " calls "))
+			return
+		}
+	}
+	if len(call.Args) != len(mixin.Params) {
+		e.errors.Add(call.Pos, "mixin "+call.Text+" takes "+strconv.Itoa(len(mixin.Params))+
+			" arguments, called with "+strconv.Itoa(len(call.Args)))
+		return
+	}
+
+	content := call.Children
+	call.Children = nil
+	for _, c := range mixin.Children {
+		call.AppendChild(cloneNode(c, call))
+	}
+	// found before filling, so the content isn't searched
+	var slots, forwards []*Node
+	Walk(call, func(n *Node) bool {
+		switch {
+		case n.Type == NodeBlock && n.Text == "":
+			slots = append(slots, n)
+		case n.ForwardAttrs && n != call:
+			forwards = append(forwards, n)
+		}
+		return true
+	})
+	for _, slot := range slots {
+		slot.Children = nil
+		for _, c := range content {
+			slot.AppendChild(cloneNode(c, slot))
+		}
+		// already expanded clones need not be expanded again, as long as the
+		// originals are
+		Walk(slot, func(n *Node) bool {
+			if n.Type == NodeCall {
+				e.expanded[n] = true
+			}
+			return true
+		})
+	}
+	for _, n := range forwards {
+		for _, attr := range call.Attrs {
+			if !n.addAttr(attr.Pos, attr.Name, attr.Value, callerParts(attr.Parts), attr.Raw) {
+				e.errors.Add(attr.Pos, "attribute "+attr.Name+" is already set by mixin "+call.Text)
+			}
+		}
+	}
+	call.Params = mixin.Params
+	for _, c := range call.Children {
+		e.expandAll(c, append(stack, call.Text))
+	}
+}
+
+// callerParts returns a copy of the interpolations of a call's attribute for a
+// tag of the mixin body, where they are evaluated with the caller's variables.
+func callerParts(parts []*Part) []*Part {
+	if parts == nil {
+		return nil
+	}
+	c := make([]*Part, len(parts))
+	for i, part := range parts {
+		p := *part
+		if p.Expr != nil {
+			p.Expr = &callerExpr{p.Expr}
+		}
+		c[i] = &p
+	}
+	return c
+}
+
+// callerExpr is an expression of a call's attribute forwarded to the mixin
+// body. It is evaluated with the variables of the call hidden, like the
+// content of the call.
+type callerExpr struct {
+	X Expr
+}
+
+func (e *callerExpr) Pos() Position  { return e.X.Pos() }
+func (e *callerExpr) String() string { return e.X.String() }
+
+// cloneNode returns a deep copy of the tree n for the new parent, except for
+// expressions and interpolations, which are not changed once parsed.
+func cloneNode(n, parent *Node) *Node {
+	c := *n
+	c.Parent = parent
+	c.Attrs = nil
+	for _, attr := range n.Attrs {
+		a := *attr
+		a.Parts = append([]*Part(nil), attr.Parts...)
+		c.Attrs = append(c.Attrs, &a)
+	}
+	c.Children = nil
+	for _, child := range n.Children {
+		c.Children = append(c.Children, cloneNode(child, &c))
+	}
+	if n.Else != nil {
+		c.Else = cloneNode(n.Else, parent)
+	}
+	return &c
+}
+
+// callScopes keeps track of the variables of the mixin calls being rendered,
+// so that the content of a call can be rendered with the variables of its
+// caller instead of those of the mixin.
+type callScopes struct {
+	// number of variables in scope when each call started, innermost last
+	marks []int
+	// ranges of variables hidden while the content of a call is rendered
+	hidden [][2]int
+}
+
+// enter starts a call with mark variables in scope.
+func (s *callScopes) enter(mark int) {
+	s.marks = append(s.marks, mark)
+}
+
+func (s *callScopes) leave() {
+	s.marks = s.marks[:len(s.marks)-1]
+}
+
+// isSlot reports whether n is a bare block line of the body of a call.
+func (s *callScopes) isSlot(n *Node) bool {
+	return n.Type == NodeBlock && n.Text == "" && len(s.marks) > 0
+}
+
+// enterContent hides the variables of the innermost call, with count
+// variables in scope, until the matching leaveContent. The content of the
+// call can itself have calls.
+func (s *callScopes) enterContent(count int) {
+	last := len(s.marks) - 1
+	s.hidden = append(s.hidden, [2]int{s.marks[last], count})
+	s.marks = s.marks[:last]
+}
+
+func (s *callScopes) leaveContent() {
+	last := len(s.hidden) - 1
+	s.marks = append(s.marks, s.hidden[last][0])
+	s.hidden = s.hidden[:last]
+}
+
+// isHidden reports whether the variable at index i is hidden.
+func (s *callScopes) isHidden(i int) bool {
+	for _, h := range s.hidden {
+		if h[0] <= i && i < h[1] {
+			return true
+		}
+	}
+	return false
+}
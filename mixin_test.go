@@ -0,0 +1,113 @@
+package mite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+)
+
+var mixinTests = []struct {
+	name string
+	src string
+	want string
+	err string
+}{
+	{
+		name: "params hide data",
+		src: "mixin greet(name)\n  p Hi #{name}\n+greet(\"Ann\")\np #{name}",
+		want: "<p>Hi Ann</p><p>data</p>",
+	},
+	{
+		name: "content sees the caller",
+		src: "- x = \"caller\"\nmixin m(x)\n  div #{x}\n    block\n+m(\"param\")\n  p #{x}",
+		want: "<div>param<p>caller</p></div>",
+	},
+	{
+		name: "body variables are hidden from the content",
+		src: "- x = \"caller\"\nmixin m\n  - x = \"body\"\n  div #{x}\n    block\n+m\n  p #{x}",
+		want: "<div>body<p>caller</p></div>",
+	},
+	{
+		name: "nested calls",
+		src: "mixin outer(t)\n  section\n    +inner(t + \"!\")\n      p #{t}\nmixin inner(t)\n  div #{t}\n    block\n+outer(\"o\")",
+		want: "<section><div>o!<p>o</p></div></section>",
+	},
+	{
+		name: "forwarded attributes see the caller",
+		src: "- title = \"outer\"\nmixin card(title)\n  .card&attributes\n    h2 #{title}\n    +inner&attributes\nmixin inner\n  span&attributes\n+card(\"inner\").wide data-t=#{title}",
+		want: "<div class='card wide' data-t='outer'><h2>inner</h2><span class='wide' data-t='outer'></span></div>",
+	},
+	{
+		name: "arguments from a loop",
+		src: "mixin item(x)\n  li #{x}\nul\n  each v in list\n    +item(v * 2)",
+		want: "<ul><li>2</li><li>4</li></ul>",
+	},
+	{
+		name: "cycle",
+		src: "mixin a\n  +b\nmixin b\n  +a\n+a",
+		err: "4:3: mixin cycle: a calls b calls a",
+	},
+	{
+		name: "argument count",
+		src: "mixin m(a, b)\n  p\n+m(1)",
+		err: "3:1: mixin m takes 2 arguments, called with 1",
+	},
+	{
+		name: "undefined",
+		src: "+nosuch",
+		err: "1:1: mixin nosuch not defined",
+	},
+	{
+		name: "defined twice",
+		src: "mixin m\n  p\nmixin m\n  p",
+		err: "3:1: mixin m already defined at 1:1",
+	},
+	{
+		name: "forwarded attribute already set",
+		src: "mixin m\n  p#x&attributes\n+m#y",
+		err: "3:3: attribute id is already set by mixin m",
+	},
+}
+
+// TestMixins checks the output of Execute and of the text/template source,
+// which keep track of the variables of calls separately.
+func TestMixins(t *testing.T) {
+	data := map[string]interface{}{"name": "data", "list": []int{1, 2}}
+	for _, test := range mixinTests {
+		tmpl, err := Compile(strings.NewReader(test.src))
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if b.String() != test.want {
+			t.Errorf("%s: output %q, want %q", test.name, b.String(), test.want)
+		}
+
+		src, err := tmpl.TextTemplate()
+		if err != nil {
+			t.Errorf("%s: TextTemplate: %s", test.name, err)
+			continue
+		}
+		text, err := texttemplate.New(test.name).Funcs(GoTemplateFuncs()).Parse(src)
+		if err != nil {
+			t.Errorf("%s: parsing %q: %s", test.name, src, err)
+			continue
+		}
+		b.Reset()
+		if err := text.Execute(&b, data); err != nil {
+			t.Errorf("%s: text/template: %s", test.name, err)
+		} else if b.String() != test.want {
+			t.Errorf("%s: text/template output %q, want %q", test.name, b.String(), test.want)
+		}
+	}
+}
@@ -1,7 +1,8 @@
-package main
+package mite
 
 import (
 	"fmt"
+	"strings"
 )
 
 type NodeType int
@@ -13,6 +14,19 @@ const (
 	NodeText
 	NodeComment
 	NodeDoctype
+	NodeHTMLComment
+	NodeIf
+	NodeElse
+	NodeEach
+	NodeAssign
+	NodeFilter
+	NodeInclude
+	NodeExtends
+	NodeBlock
+	NodeAppend
+	NodePrepend
+	NodeMixin
+	NodeCall
 )
 
 var NodeTypeString = map[NodeType]string {
@@ -22,25 +36,85 @@ var NodeTypeString = map[NodeType]string {
 	NodeText:		"Text",
 	NodeComment:	"Comment",
 	NodeDoctype:	"Doctype",
+	NodeHTMLComment:	"HTMLComment",
+	NodeIf:			"If",
+	NodeElse:		"Else",
+	NodeEach:		"Each",
+	NodeAssign:		"Assign",
+	NodeFilter:		"Filter",
+	NodeInclude:	"Include",
+	NodeExtends:	"Extends",
+	NodeBlock:		"Block",
+	NodeAppend:		"Append",
+	NodePrepend:	"Prepend",
+	NodeMixin:		"Mixin",
+	NodeCall:		"Call",
 }
 
+// Node is an element of the tree built by the Parser. Which fields are used
+// depends on Type.
 type Node struct {
 	Type NodeType
-	parent *Node
 
-	// were tags printed out? important to know if NodeText follows and needs
-	// to append more text
-	opened bool
-	closed bool
+	// position of the first token of the node in the template source
+	Pos Position
 
-	tag string
-	attrs map[string]string
-	text string
+	Parent *Node
+	Children []*Node
 
-	// dumb appended string while attribute assignment is still being determined
-	// this is used if it turns out tokens are NOT part of an attribute assignment
-	// and the string (with whitespace if any) gets used as the node's text
-	attrString string
+	// NodeTag
+	Tag string
+	// in the order they are first assigned in the source
+	Attrs []*Attr
+	// written as <tag/> in every output mode
+	SelfClosing bool
+
+	// NodeText, the text of NodeDoctype and comments, the nested lines of
+	// NodeFilter, the template name of NodeInclude and NodeExtends, and the
+	// block name of NodeBlock, NodeAppend and NodePrepend, and the mixin name
+	// of NodeMixin and NodeCall
+	Text string
+	// Text split up into literal text and #{expr} interpolations. nil if the
+	// text has no interpolations
+	Parts []*Part
+
+	// NodeIf condition, the collection of NodeEach, or the value of NodeAssign
+	Expr Expr
+	// the else if (NodeIf) or else (NodeElse) following a NodeIf, or the else
+	// of a NodeEach rendered for an empty collection. it is not one of the
+	// parent's Children
+	Else *Node
+
+	// NodeEach variables for the element, and the index or map key. Key is
+	// empty if there is only one. Var is also the variable of NodeAssign
+	Var string
+	Key string
+
+	// NodeFilter name, without the colon
+	Filter string
+
+	// NodeMixin parameter names, and the NodeCall arguments. once a call is
+	// expanded, Params are the parameters of its mixin and Children its body
+	Params []string
+	Args []Expr
+
+	// NodeTag or NodeCall in a mixin, marked with &attributes, that gets the
+	// attributes of the calls of the mixin
+	ForwardAttrs bool
+
+	// Text is output as is instead of being HTML escaped
+	Raw bool
+}
+
+// Attr is a tag attribute assignment, name=value, or name!=value for a value
+// that is output as is instead of being HTML escaped.
+type Attr struct {
+	Pos Position
+	Name string
+	Value string
+	// Value split up like Node.Parts
+	Parts []*Part
+	Raw bool
 }
 
 func (n *Node) TypeString() string {
@@ -51,42 +125,106 @@ func (n *Node) TypeString() string {
 	}
 }
 
-func (n *Node) OpenString() string {
-	output := ""
-	if n.Type == NodeTag && !n.opened {
-		output += "<" + n.tag
-		for k, v := range n.attrs {
-			output += fmt.Sprintf(" %s='%s'", k, v)
+// Attr returns the attribute with the given name, or nil if the node has no
+// such attribute.
+func (n *Node) Attr(name string) *Attr {
+	for _, attr := range n.Attrs {
+		if attr.Name == name {
+			return attr
 		}
-		output += ">"
-		n.opened = true
 	}
-	if n.Type == NodeTag || n.Type == NodeText {
-		output += n.attrString
-		output += n.text
-		n.attrString = ""
-		n.text = ""
+	return nil
+}
+
+// addAttr assigns an attribute. The value of a class already assigned is
+// appended to it. Any other attribute can only be assigned once, and addAttr
+// reports false if it already is.
+func (n *Node) addAttr(pos Position, name, value string, parts []*Part, raw bool) bool {
+	attr := n.Attr(name)
+	if attr == nil {
+		n.Attrs = append(n.Attrs, &Attr{pos, name, value, parts, raw})
+		return true
+	}
+	if name != "class" {
+		return false
 	}
-	return output
+	if attr.Parts != nil || parts != nil {
+		if attr.Parts == nil {
+			attr.Parts = []*Part{{Pos: attr.Pos, Text: attr.Value}}
+		}
+		if parts == nil {
+			parts = []*Part{{Pos: pos, Text: value}}
+		}
+		attr.Parts = append(attr.Parts, &Part{Pos: pos, Text: " "})
+		attr.Parts = append(attr.Parts, parts...)
+	}
+	attr.Value += " "
+	attr.Value += value
+	// only skip escaping when every value is raw
+	attr.Raw = attr.Raw && raw
+	return true
 }
 
-func (n *Node) CloseString() string {
-	output := ""
-	if n.Type == NodeTag && !n.closed {
-		output += "</" + n.tag + ">"
-		n.closed = true
+// AppendChild adds c as the last child of n.
+func (n *Node) AppendChild(c *Node) {
+	c.Parent = n
+	n.Children = append(n.Children, c)
+}
+
+// Walk calls fn for n and every node below it, depth first, including the
+// else branches of NodeIf and NodeEach. The nodes below n are skipped if fn returns false.
+func Walk(n *Node, fn func(*Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, c := range n.Children {
+		Walk(c, fn)
+	}
+	if n.Else != nil {
+		Walk(n.Else, fn)
 	}
-	return output
 }
 
 func (n *Node) Debug() string {
 	output := ""
 	output += fmt.Sprintf("[Type:%s]", n.TypeString())
-	output += fmt.Sprintf("[opened:%t]", n.opened)
-	output += fmt.Sprintf("[closed:%t]", n.closed)
-	output += fmt.Sprintf("[tag:%s]", n.tag)
-	output += fmt.Sprintf("[attrs:%s]", n.attrs)
-	output += fmt.Sprintf("[text(%d):%s]", len(n.text), n.text)
-	output += fmt.Sprintf("[attrString:%s]", n.attrString)
+	output += fmt.Sprintf("[Pos:%s]", n.Pos)
+	output += fmt.Sprintf("[Tag:%s]", n.Tag)
+	output += "[Attrs:"
+	for _, attr := range n.Attrs {
+		output += fmt.Sprintf(" %s=%q", attr.Name, attr.Value)
+	}
+	output += "]"
+	output += fmt.Sprintf("[Text(%d):%s]", len(n.Text), n.Text)
+	output += fmt.Sprintf("[Raw:%t]", n.Raw)
+	if n.Var != "" {
+		output += fmt.Sprintf("[Var:%s]", n.Var)
+	}
+	if n.Key != "" {
+		output += fmt.Sprintf("[Key:%s]", n.Key)
+	}
+	if n.Filter != "" {
+		output += fmt.Sprintf("[Filter:%s]", n.Filter)
+	}
+	if n.Expr != nil {
+		output += fmt.Sprintf("[Expr:%s]", n.Expr)
+	}
+	if n.Params != nil {
+		output += fmt.Sprintf("[Params:%s]", strings.Join(n.Params, ", "))
+	}
+	if n.Args != nil {
+		output += "[Args:"
+		for i, arg := range n.Args {
+			if i > 0 {
+				output += ","
+			}
+			output += fmt.Sprintf(" %s", arg)
+		}
+		output += "]"
+	}
+	if n.ForwardAttrs {
+		output += "[ForwardAttrs]"
+	}
+	output += fmt.Sprintf("[Children:%d]", len(n.Children))
 	return output
 }
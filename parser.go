@@ -1,61 +1,133 @@
-package main
+package mite
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"strings"
+	"unicode"
 )
 
+// Parser builds a tree of Nodes from the tokens of its Scanner.
 type Parser struct {
 
 	debug bool
 
 	Scanner Scanner
-	output string
 
-	// node heirarchy stack
+	errors ErrorList
+
+	root *Node
+
+	// block heirarchy stack. the last node is the parent of the current line
 	stack []*Node
 
-	// edge in stack
+	// node of the current line
 	node *Node
 
-	// states as the Parser object figures out how to output the tokens
+	// node of the previous line, which becomes the parent on indent
+	last *Node
 
-	// new line has started
-	isNewLine bool
+	// text or comment node that absorbs lines indented deeper than itself, and
+	// how much deeper than it the current line is
+	textNode *Node
+	textDepth int
+	textLine int
 
-	isIndent bool
-	isDedent bool
+	// states as the Parser object figures out what the tokens of a line are
 
 	// flag indicates we are still checking for attribute assignments
 	isAttr bool
 
-	isStringFlag bool
+	// flag indicates class and id literals can still follow the tag
+	isShorthand bool
+
+	// flag indicates the last token was the tag, a literal or an attribute
+	// value, which can be followed by / to make the tag self-closing
+	isClosable bool
+
+	// flag indicates the rest of the line is ignored after an error
+	isSkip bool
 
-	// attrName and attrValue are buffers to determine if TokWord for a tag are 
-	// potentially for an attribute, or if they are just normal text
+	// flag indicates the text of the line is output without escaping
+	isRaw bool
+
+	// flag indicates the mixin name and arguments of a call line are being
+	// read, and how deep in their parentheses the line is
+	isCall bool
+	argDepth int
+
+	// flag indicates the last token was an & among the attributes, which may
+	// be the start of &attributes
+	isAmp bool
+
+	// attrName and attrAssigned are buffers to determine if TokWord for a tag
+	// are potentially for an attribute, or if they are just normal text
 	attrName string
-	attrValue string
+	attrPos Position
 	attrAssigned bool
+	attrRaw bool
+
+	// dumb appended string while attribute assignment is still being determined
+	// this is used if it turns out tokens are NOT part of an attribute assignment
+	// and the string (with whitespace if any) gets used as the node's text
+	attrString string
+
+	// text following the tag and attributes
+	text string
+	textPos Position
 
 }
 
+// Output returns the whole rendered document as a string. Use Render to
+// stream large documents instead.
 func (p *Parser) Output() string {
+	var b bytes.Buffer
+	p.Render(&b)
+	return b.String()
+}
+
+// Render parses the template and writes its HTML to w.
+func (p *Parser) Render(w io.Writer) error {
+	root, err := p.Parse()
+	if err != nil {
+		return err
+	}
+	if err := expandMixins(root); err != nil {
+		return err
+	}
+	r := newRenderer(w)
+	return r.render(root)
+}
+
+// Parse scans the whole template and returns the root of its node tree.
+// Scanning and parsing errors are returned as an ErrorList.
+func (p *Parser) Parse() (*Node, error) {
 	var tokens []rune
 	var text string
 
+	if p.Scanner.IsWordRune == nil {
+		p.Scanner.IsWordRune = IsNameRune
+	}
+	if p.Scanner.Error == nil {
+		p.Scanner.Error = func(s *Scanner, msg string) {
+			pos := s.Position
+			if !pos.IsValid() {
+				pos = s.Pos()
+			}
+			p.error(pos, msg)
+		}
+	}
+
+	p.errors = nil
+	p.root = p.newNode()
+	p.root.Type = NodeRoot
+	p.stack = []*Node{p.root}
 	p.node = p.newNode()
-	p.node.Type = NodeRoot
-	p.stack = make([]*Node, 0)
-	p.stack = append(p.stack, p.node)
-
-	p.output = ""
-	p.isNewLine = true
-	p.isIndent = false
-	p.isDedent = false
-	p.isAttr = false
-	p.attrName = ""
-	p.attrValue = ""
-	p.attrAssigned = false
+	p.last = nil
+	p.textNode = nil
+	p.textDepth = 0
+	p.resetLine()
 
 	for {
 		tokens = p.Scanner.Scan()
@@ -74,16 +146,33 @@ func (p *Parser) Output() string {
 			break
 		}
 	}
-	return p.output
+	p.parseInterps(p.root)
+	return p.root, p.errors.Err()
 }
 
-func (p *Parser) trimOutput() {
-	p.output = strings.TrimSpace(p.output)
+// parseInterps finds the interpolations in the text nodes of the tree rooted
+// at n. Text nodes can absorb more lines until the end of their block, so this
+// is done once the whole tree is built.
+func (p *Parser) parseInterps(root *Node) {
+	Walk(root, func(n *Node) bool {
+		if n.Type == NodeText {
+			parts, err := parseInterp(n.Text, n.Pos)
+			if err != nil {
+				p.errors = append(p.errors, err.(ErrorList)...)
+			}
+			n.Parts = parts
+		}
+		return true
+	})
+}
+
+func (p *Parser) error(pos Position, msg string) {
+	p.errors.Add(pos, msg)
 }
 
 func (p *Parser) newNode() *Node {
 	n := new(Node)
-	n.attrs = make(map[string]string)
+	n.Pos = p.Scanner.Position
 	return n
 }
 
@@ -97,224 +186,795 @@ func (p *Parser) lastNode() *Node {
 
 func (p *Parser) popNode() *Node {
 	n := p.lastNode()
-	if n != nil {
+	// the root node always stays at the bottom of the stack
+	if len(p.stack) > 1 {
 		p.stack = p.stack[0:len(p.stack)-1]
 	}
 	return n
 }
 
 func (p *Parser) pushNode(n *Node) {
-	ln := p.lastNode()
-	n.parent = ln
 	p.stack = append(p.stack, n)
 }
 
-func (p *Parser) dedentFromStack() {
-	n := p.lastNode()
-	if n != nil && n.Type != NodeRoot {
-		p.output += n.OpenString()
-		p.trimOutput()
-		// newlines in NodeText have spaces. Adding one here for consistency
-		if n.Type == NodeText {
-			p.output += " "
+// addNode sets the type of the current line's node and attaches it to the
+// parent block. Lines that never get a type, like comments, are not attached
+// and neither are the lines indented under them.
+func (p *Parser) addNode(t NodeType) {
+	parent := p.lastNode()
+	p.node.Type = t
+	p.node.Pos = p.Scanner.Position
+	switch parent.Type {
+	case NodeDoctype:
+		p.error(p.node.Pos, "doctype cannot have nested lines")
+	case NodeAssign:
+		p.error(p.node.Pos, "assignment cannot have nested lines")
+	case NodeInclude:
+		p.error(p.node.Pos, "include cannot have nested lines")
+	case NodeExtends:
+		p.error(p.node.Pos, "extends cannot have nested lines")
+	}
+	if t == NodeMixin && p.inMixin() {
+		p.error(p.node.Pos, "mixin definitions cannot be nested")
+	}
+	p.checkContent(parent, p.node.Pos)
+	parent.AppendChild(p.node)
+}
+
+// checkContent reports an error if content is added to a self-closing tag.
+// Void elements depend on the output mode, which is only known once the
+// template is rendered, so checkVoid reports them.
+func (p *Parser) checkContent(parent *Node, pos Position) {
+	if parent.Type == NodeTag && parent.SelfClosing {
+		p.error(pos, fmt.Sprintf("self-closing tag %s cannot have content", parent.Tag))
+	}
+}
+
+// addDoctype adds a doctype line. The rest of the line is the node's text.
+func (p *Parser) addDoctype() {
+	pos := p.Scanner.Position
+	if p.lastNode() != p.root {
+		p.error(pos, "doctype must be at the top level")
+	} else if len(p.root.Children) > 0 {
+		if p.root.Children[0].Type == NodeDoctype {
+			p.error(pos, "duplicate doctype")
+		} else {
+			p.error(pos, "doctype must come before any other content")
 		}
-		p.output += n.CloseString()
-		p.popNode()
 	}
+	p.addNode(NodeDoctype)
+}
+
+// addExtends adds an extends line, which must be the first line other than
+// comments.
+func (p *Parser) addExtends() {
+	pos := p.Scanner.Position
+	if p.lastNode() != p.root {
+		p.error(pos, "extends must be at the top level")
+	} else {
+		for _, n := range p.root.Children {
+			if n.Type != NodeComment {
+				p.error(pos, "extends must come before any other content")
+				break
+			}
+		}
+	}
+	p.addNode(NodeExtends)
+}
+
+// inMixin reports whether the current line is in the body of a mixin.
+func (p *Parser) inMixin() bool {
+	for _, n := range p.stack {
+		if n.Type == NodeMixin {
+			return true
+		}
+	}
+	return false
+}
+
+// addComment adds a line starting with a comment. "/!" comments are written
+// to the output, with any nested lines inside them. Other comments are
+// template comments that are dropped from the output along with the lines
+// nested under them.
+func (p *Parser) addComment(text string) {
+	switch {
+	case strings.HasPrefix(text, "/!"):
+		p.addNode(NodeHTMLComment)
+		text = text[2:]
+	case strings.HasPrefix(text, "/*"):
+		p.addNode(NodeComment)
+		text = strings.TrimSuffix(text[2:], "*/")
+	default:
+		p.addNode(NodeComment)
+		text = text[2:]
+	}
+	p.node.Text = strings.TrimSpace(text)
+}
+
+// addElse adds an else line to the if or each right before it at the same
+// level. The else is linked from them rather than being a child of the block.
+func (p *Parser) addElse() {
+	parent := p.lastNode()
+	p.node.Type = NodeElse
+	p.node.Pos = p.Scanner.Position
+	p.node.Parent = parent
+
+	var prev *Node
+	for i := len(parent.Children) - 1; i >= 0; i-- {
+		if parent.Children[i].Type != NodeComment {
+			prev = parent.Children[i]
+			break
+		}
+	}
+	if prev == nil || prev.Type != NodeIf && prev.Type != NodeEach {
+		p.error(p.node.Pos, "else without if or each")
+		return
+	}
+	for prev.Else != nil {
+		prev = prev.Else
+	}
+	if prev.Type == NodeElse {
+		p.error(p.node.Pos, "else after else")
+		return
+	}
+	prev.Else = p.node
+}
+
+// endCondition parses the condition of an if or an else if line.
+func (p *Parser) endCondition() {
+	src := strings.TrimRightFunc(p.text, unicode.IsSpace)
+	pos := p.textPos
+	if p.node.Type == NodeElse {
+		if src == "" {
+			return
+		}
+		if p.node.Parent != nil && p.isElseOfEach() {
+			p.error(pos, "each can only be followed by a plain else")
+			return
+		}
+		fields := strings.Fields(src)
+		if fields[0] != "if" {
+			p.error(pos, fmt.Sprintf("unexpected %s after else", fields[0]))
+			return
+		}
+		// else if
+		p.node.Type = NodeIf
+		cond := strings.TrimLeftFunc(src[2:], unicode.IsSpace)
+		pos = advance(pos, src[:len(src)-len(cond)])
+		src = cond
+	}
+	if src == "" {
+		p.error(p.node.Pos, "if without condition")
+		return
+	}
+	expr, err := ParseExpr(src, pos)
+	if err != nil {
+		p.errors = append(p.errors, err.(ErrorList)...)
+	}
+	p.node.Expr = expr
+}
+
+// isElseOfEach reports whether the current else line follows an each.
+func (p *Parser) isElseOfEach() bool {
+	for _, n := range p.node.Parent.Children {
+		if n.Type == NodeEach && n.Else == p.node {
+			return true
+		}
+	}
+	return false
+}
+
+// endEach parses the "item[, key] in expr" of an each or for line.
+func (p *Parser) endEach() {
+	src := strings.TrimRightFunc(p.text, unicode.IsSpace)
+	i := indexWord(src, "in")
+	if i < 0 {
+		p.error(p.node.Pos, "expected each item[, key] in expression")
+		return
+	}
+	names := strings.Split(src[:i], ",")
+	if len(names) > 2 {
+		p.error(p.textPos, "expected each item[, key] in expression")
+		return
+	}
+	for k, name := range names {
+		name = strings.TrimSpace(name)
+		if !isIdent(name) {
+			p.error(p.textPos, fmt.Sprintf("bad loop variable name %q", name))
+			return
+		}
+		if k == 0 {
+			p.node.Var = name
+		} else {
+			p.node.Key = name
+		}
+	}
+
+	cond := strings.TrimLeftFunc(src[i+2:], unicode.IsSpace)
+	if cond == "" {
+		p.error(p.node.Pos, "each without expression")
+		return
+	}
+	expr, err := ParseExpr(cond, advance(p.textPos, src[:len(src)-len(cond)]))
+	if err != nil {
+		p.errors = append(p.errors, err.(ErrorList)...)
+	}
+	p.node.Expr = expr
+}
+
+// endAssign parses the "name = expr" of an assignment line.
+func (p *Parser) endAssign() {
+	src := strings.TrimRightFunc(p.text, unicode.IsSpace)
+	i := strings.Index(src, "=")
+	if i < 0 {
+		p.error(p.node.Pos, "expected - name = expression")
+		return
+	}
+	name := strings.TrimSpace(src[:i])
+	if !isIdent(name) {
+		p.error(p.textPos, fmt.Sprintf("bad variable name %q", name))
+		return
+	}
+	p.node.Var = name
+
+	value := strings.TrimLeftFunc(src[i+1:], unicode.IsSpace)
+	if value == "" {
+		p.error(p.node.Pos, "assignment without expression")
+		return
+	}
+	expr, err := ParseExpr(value, advance(p.textPos, src[:len(src)-len(value)]))
+	if err != nil {
+		p.errors = append(p.errors, err.(ErrorList)...)
+	}
+	p.node.Expr = expr
+}
+
+// endMixin parses the "name(param, ...)" of a mixin line. The parentheses can
+// be left out for a mixin without parameters.
+func (p *Parser) endMixin() {
+	src := strings.TrimRightFunc(p.text, unicode.IsSpace)
+	name, params := src, ""
+	if i := strings.Index(src, "("); i >= 0 {
+		if !strings.HasSuffix(src, ")") {
+			p.error(p.textPos, "expected mixin name(parameters)")
+			return
+		}
+		name, params = src[:i], src[i+1:len(src)-1]
+	}
+	name = strings.TrimSpace(name)
+	if !isIdent(name) {
+		p.error(p.node.Pos, fmt.Sprintf("bad mixin name %q", name))
+		return
+	}
+	p.node.Text = name
+	if strings.TrimSpace(params) == "" {
+		return
+	}
+	for _, param := range strings.Split(params, ",") {
+		param = strings.TrimSpace(param)
+		if !isIdent(param) {
+			p.error(p.textPos, fmt.Sprintf("bad parameter name %q", param))
+			return
+		}
+		for _, prev := range p.node.Params {
+			if prev == param {
+				p.error(p.textPos, fmt.Sprintf("duplicate parameter %s", param))
+				return
+			}
+		}
+		p.node.Params = append(p.node.Params, param)
+	}
+}
+
+// addCallToken adds a token of the mixin name or arguments of a call line.
+// The arguments are read as the text of a call expression, name(args), so
+// the expression parser splits them.
+func (p *Parser) addCallToken(tok rune, text string) {
+	switch {
+	case p.node.Text == "":
+		if tok != TokWord || p.Scanner.Position.Offset != p.node.Pos.Offset+1 {
+			p.error(p.Scanner.Position, "expected mixin name after +")
+			p.isSkip = true
+			return
+		}
+		p.node.Text = text
+		p.text = text
+		p.textPos = p.Scanner.Position
+	case p.argDepth > 0:
+		p.text += text
+		switch tok {
+		case '(':
+			p.argDepth++
+		case ')':
+			p.argDepth--
+			if p.argDepth == 0 {
+				p.endCall()
+			}
+		}
+	case tok == '(' && p.Scanner.Position.Offset == p.textPos.Offset+len(p.text):
+		p.text += text
+		p.argDepth = 1
+	default:
+		// no arguments, so the token is the first of the attributes
+		p.endCall()
+		p.processToken(tok, text)
+	}
+}
+
+// endCall parses the mixin name and arguments of a call line. The rest of the
+// line is read like the attributes and text of a tag.
+func (p *Parser) endCall() {
+	p.isCall = false
+	p.isAttr = true
+	p.isShorthand = true
+	src := p.text
+	p.text = ""
+	switch {
+	case p.node.Text == "":
+		if !p.isSkip {
+			// the line is only +. otherwise addCallToken reported it
+			pos := p.node.Pos
+			pos.Offset++
+			pos.Column++
+			p.error(pos, "expected mixin name after +")
+		}
+		return
+	case p.argDepth > 0:
+		p.error(p.textPos, "mixin call without closing )")
+		return
+	case !isIdent(p.node.Text):
+		p.error(p.node.Pos, fmt.Sprintf("bad mixin name %q", p.node.Text))
+		return
+	case src == p.node.Text:
+		return
+	}
+	expr, err := ParseExpr(src, p.textPos)
+	if err != nil {
+		p.errors = append(p.errors, err.(ErrorList)...)
+		return
+	}
+	if call, ok := expr.(*Call); ok {
+		p.node.Args = call.Args
+	}
+}
+
+// indexWord returns the index of the first whitespace separated word in s, or
+// -1 if it is not there.
+func indexWord(s, word string) int {
+	for i := 0; i+len(word) <= len(s); i++ {
+		if s[i:i+len(word)] != word {
+			continue
+		}
+		if i > 0 && !unicode.IsSpace(rune(s[i-1])) {
+			continue
+		}
+		if i+len(word) < len(s) && !unicode.IsSpace(rune(s[i+len(word)])) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// isName reports whether s is a valid name for IsNameRune, such as a block
+// name.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	i := 0
+	for _, ch := range s {
+		if !IsNameRune(ch, i) {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// isIdent reports whether s is a name that can be used as a variable.
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, ch := range s {
+		if ch != '_' && !unicode.IsLetter(ch) && (i == 0 || !unicode.IsDigit(ch)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Parser) resetLine() {
+	p.isAttr = false
+	p.isShorthand = false
+	p.isClosable = false
+	p.isSkip = false
+	p.isRaw = false
+	p.isCall = false
+	p.argDepth = 0
+	p.isAmp = false
+	p.attrName = ""
+	p.attrAssigned = false
+	p.attrRaw = false
+	p.attrString = ""
+	p.text = ""
+}
+
+// endLine finishes the current line's node once all of its tokens are seen.
+func (p *Parser) endLine() {
+	if p.textNode != nil && p.textDepth > 0 {
+		// line was absorbed by the text node
+		p.textNode.Text = strings.TrimRightFunc(p.textNode.Text, unicode.IsSpace)
+		return
+	}
+
+	switch p.node.Type {
+	case NodeTag, NodeCall:
+		if p.isCall {
+			p.endCall()
+		}
+		if p.isAmp {
+			p.beginText()
+			p.appendText("&")
+		}
+		// a lone word after the tag, or an attribute without a value, is text
+		if p.isAttr {
+			p.beginText()
+		}
+		if p.text = strings.TrimRightFunc(p.text, unicode.IsSpace); p.text != "" {
+			n := p.newNode()
+			n.Type = NodeText
+			n.Pos = p.textPos
+			n.Text = p.text
+			n.Raw = p.isRaw
+			p.checkContent(p.node, n.Pos)
+			p.node.AppendChild(n)
+		}
+	case NodeDoctype:
+		p.node.Text = strings.TrimRightFunc(p.node.Text, unicode.IsSpace)
+	case NodeHTMLComment:
+		p.node.Text = strings.TrimRightFunc(p.node.Text, unicode.IsSpace)
+	case NodeText, NodeComment:
+		p.node.Text = strings.TrimRightFunc(p.node.Text, unicode.IsSpace)
+		p.textNode = p.node
+		p.textDepth = 0
+		p.textLine = p.node.Pos.Line
+	case NodeIf, NodeElse:
+		p.endCondition()
+	case NodeEach:
+		p.endEach()
+	case NodeAssign:
+		p.endAssign()
+	case NodeInclude, NodeExtends:
+		p.node.Text = strings.TrimRightFunc(p.text, unicode.IsSpace)
+		if p.node.Text == "" {
+			p.error(p.node.Pos, fmt.Sprintf("%s without template name", strings.ToLower(p.node.TypeString())))
+		}
+	case NodeBlock, NodeAppend, NodePrepend:
+		p.node.Text = strings.TrimRightFunc(p.text, unicode.IsSpace)
+		if p.node.Type == NodeBlock && p.node.Text == "" && p.inMixin() {
+			// where the lines nested under a call go
+		} else if !isName(p.node.Text) {
+			p.error(p.node.Pos, fmt.Sprintf("%s without a valid block name", strings.ToLower(p.node.TypeString())))
+		}
+	case NodeMixin:
+		p.endMixin()
+	case NodeFilter:
+		if p.node.Filter == "" {
+			p.error(p.node.Pos, "filter without name")
+		}
+		// the nested lines are the text of the filter, as is
+		p.node.Text = p.Scanner.ScanBlock()
+	}
+	p.last = p.node
+	p.resetLine()
+}
+
+func (p *Parser) beginText() {
+	p.isAttr = false
+	if p.text == "" && p.attrString != "" {
+		p.textPos = p.attrPos
+	}
+	p.text += p.attrString
+	p.attrName = ""
+	p.attrAssigned = false
+	p.attrRaw = false
+	p.attrString = ""
+}
+
+func (p *Parser) appendText(text string) {
+	if p.text == "" {
+		// skip initial whitespace for text
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		p.textPos = p.Scanner.Position
+	}
+	p.text += text
+}
+
+func (p *Parser) addAttr(pos Position, name, value string, parts []*Part, raw bool) {
+	if !p.node.addAttr(pos, name, value, parts, raw) {
+		p.error(pos, "duplicate attribute "+name)
+	}
+}
+
+// addShorthand adds the class or id of a .class or #id literal.
+func (p *Parser) addShorthand(tok rune, text string) {
+	switch tok {
+	case TokClass:
+		p.addAttr(p.Scanner.Position, "class", text[1:], nil, false)
+	case TokID:
+		if p.node.Attr("id") != nil {
+			p.error(p.Scanner.Position, "duplicate id literal "+text)
+			return
+		}
+		p.addAttr(p.Scanner.Position, "id", text[1:], nil, false)
+	}
+}
+
+// setAttr assigns value to the pending attribute name. pos is the position of
+// the value, for errors in any interpolations in it.
+func (p *Parser) setAttr(value string, pos Position) {
+	parts, err := parseInterp(value, pos)
+	if err != nil {
+		p.errors = append(p.errors, err.(ErrorList)...)
+	}
+	p.addAttr(p.attrPos, p.attrName, value, parts, p.attrRaw)
+	p.isClosable = true
+
+	// reset to look for a new attribute assignment
+	p.attrName = ""
+	p.attrAssigned = false
+	p.attrRaw = false
+	p.attrString = ""
 }
 
 func (p *Parser) processToken(tok rune, text string) {
-	// indent/dedent/nodent is the first place to look to see if new nodes need
-	// to be made. checks are for parent node being certain types like NodeText
-	// or NodeComment
+	// indent/dedent/nodent is the first place to look to see which block the
+	// line belongs to. lines indented under a text node are part of its text
 	switch tok {
 	case TokIndent:
-		p.isIndent = true
-		p.isDedent = false
-		if p.node.Type != NodeText {
-			p.output += p.node.OpenString()
-			p.node = p.newNode()
-			p.pushNode(p.node)
+		if p.textNode != nil {
+			p.textDepth++
+			return
 		}
+		if p.last != nil {
+			p.pushNode(p.last)
+		}
+		p.node = p.newNode()
+		return
 	case TokDedent:
-		p.isIndent = false
-		p.isDedent = true
-		p.dedentFromStack()
-		if p.node.Type != NodeText {
-			p.dedentFromStack()
+		if p.textNode != nil && p.textDepth > 0 {
+			p.textDepth--
+			if p.textDepth == 0 {
+				// back to the level of the text node, so this is a sibling
+				p.textNode = nil
+				p.node = p.newNode()
+			}
+			return
 		}
+		p.textNode = nil
+		p.popNode()
 		p.node = p.newNode()
-		p.pushNode(p.node)
+		return
 	case TokNodent:
-		p.isIndent = false
-		p.isDedent = false
-		switch p.node.Type {
-		case NodeRoot:
-			p.node = p.newNode()
-			p.pushNode(p.node)
-		case NodeText:
-		default:
-			// replace top of stack with new node (pop then push)
-			n := p.lastNode()
-			p.output += n.OpenString()
-			p.trimOutput()
-			p.output += n.CloseString()
-			p.popNode()
-			p.node = p.newNode()
-			p.pushNode(p.node)
-		}
-	}
-
-	if p.node.Type == NodeText && tok != TokDedent {
-		if tok != TokIndent {
-			if tok == TokNewLine {
-				p.node.text += " "
-			} else if tok != TokWhitespace || p.node.text != "" {
-				p.node.text += text
+		if p.textNode != nil && p.textDepth > 0 {
+			return
+		}
+		p.textNode = nil
+		p.node = p.newNode()
+		return
+	case TokNewLine:
+		p.endLine()
+		return
+	case TokEOF:
+		p.endLine()
+		p.stack = p.stack[0:1]
+		return
+	}
+
+	if p.isSkip {
+		return
+	}
+
+	if p.textNode != nil && p.textDepth > 0 {
+		if p.Scanner.Line != p.textLine {
+			// first token of the line
+			if tok == TokWhitespace {
+				return
 			}
+			// newlines in NodeText are spaces
+			if p.textNode.Text != "" {
+				p.textNode.Text += " "
+			}
+			p.textLine = p.Scanner.Line
 		}
+		p.textNode.Text += text
 		return
 	}
 
-	switch tok {
-	case TokWord, TokComma:
-		switch p.node.Type {
-		case NodeNil:
-			p.node.Type = NodeTag
-			p.node.tag = text
-			// found the tag, now check for attributes
+	switch p.node.Type {
+	case NodeNil:
+		switch tok {
+		case TokWord:
+			switch text {
+			case "doctype":
+				p.addDoctype()
+			case "include":
+				p.addNode(NodeInclude)
+			case "extends":
+				p.addExtends()
+			case "block":
+				p.addNode(NodeBlock)
+			case "append":
+				p.addNode(NodeAppend)
+			case "prepend":
+				p.addNode(NodePrepend)
+			case "mixin":
+				p.addNode(NodeMixin)
+			case "if":
+				p.addNode(NodeIf)
+			case "each", "for":
+				p.addNode(NodeEach)
+			case "else":
+				p.addElse()
+			default:
+				p.addNode(NodeTag)
+				p.node.Tag = text
+				// found the tag, now check for attributes
+				p.isAttr = true
+				p.isShorthand = true
+				p.isClosable = true
+			}
+		case TokClass, TokID:
+			// a line starting with a literal is a div
+			p.addNode(NodeTag)
+			p.node.Tag = "div"
+			p.addShorthand(tok, text)
 			p.isAttr = true
-			p.attrAssigned = false
-		case NodeTag:
-			if p.isAttr {
-				if p.attrAssigned {
-					if _, found := p.node.attrs[p.attrName]; found {
-						p.node.attrs[p.attrName] += " "
-						p.node.attrs[p.attrName] += text
-					} else {
-						p.node.attrs[p.attrName] = text
-					}
-
-					// reset to look for a new attribute assignment
-					p.attrName = ""
-					p.attrValue = ""
-					p.attrAssigned = false
-					p.node.attrString = ""
-				} else if p.attrName == "" {
-					p.node.attrString += text
-					p.attrName = text
-				} else {
-					p.node.text += p.node.attrString
-					p.node.text += text
-					p.node.attrString = ""
-					p.isAttr = false
-					p.attrAssigned = false
-					p.attrName = ""
-					p.attrValue = ""
-				}
-			} else {
-				p.node.text += text
+			p.isShorthand = true
+			p.isClosable = true
+		case TokStringFlag:
+			p.addNode(NodeText)
+		case TokRawFlag:
+			p.addNode(NodeText)
+			p.node.Raw = true
+		case TokComment:
+			p.addComment(text)
+		case '-':
+			p.addNode(NodeAssign)
+		case ':':
+			p.addNode(NodeFilter)
+		case '+':
+			p.addNode(NodeCall)
+			p.isCall = true
+		case TokWhitespace:
+		default:
+			p.error(p.Scanner.Position, fmt.Sprintf("unexpected %s at start of line", TokenString(tok)))
+			p.isSkip = true
+		}
+	case NodeText, NodeDoctype, NodeComment, NodeHTMLComment:
+		if tok != TokWhitespace || p.node.Text != "" {
+			p.node.Text += text
+		}
+	case NodeFilter:
+		switch {
+		case tok == TokWord && p.node.Filter == "" && p.Scanner.Position.Offset == p.node.Pos.Offset+1:
+			p.node.Filter = text
+		case tok == TokWhitespace:
+		default:
+			p.error(p.Scanner.Position, fmt.Sprintf("unexpected %s after filter", TokenString(tok)))
+			p.isSkip = true
+		}
+	case NodeIf, NodeElse, NodeEach, NodeAssign, NodeInclude, NodeExtends,
+		NodeBlock, NodeAppend, NodePrepend, NodeMixin:
+		// the rest of the line is the condition, loop, assignment or name
+		p.appendText(text)
+	case NodeTag, NodeCall:
+		if p.isCall {
+			p.addCallToken(tok, text)
+			return
+		}
+		if p.isShorthand {
+			if tok == TokClass || tok == TokID {
+				p.addShorthand(tok, text)
+				return
 			}
+			p.isShorthand = false
+		}
+		if p.isClosable && tok == '/' && p.isAttr && p.node.Type == NodeTag {
+			// tag/ is always written as <tag/>. anything after it is text,
+			// which is an error
+			p.node.SelfClosing = true
+			p.beginText()
+			return
 		}
-	case TokString:
-		switch p.node.Type {
-		case NodeTag:
-			if p.isAttr {
-				if p.attrAssigned {
-					if _, found := p.node.attrs[p.attrName]; found {
-						p.node.attrs[p.attrName] += " "
-						p.node.attrs[p.attrName] += text[1:len(text)-1]
-					} else {
-						p.node.attrs[p.attrName] = text[1:len(text)-1]
-					}
-
-					// reset to look for a new attribute assignment
-					p.attrName = ""
-					p.attrValue = ""
-					p.attrAssigned = false
-					p.node.attrString = ""
-				} else if p.attrName != "" {
-					// TODO using string in attrName shouldn't be allowed
-					p.node.text += p.node.attrString
-					p.node.text += text[1:len(text)-1]
-					p.node.attrString = ""
-					p.isAttr = false
-					p.attrName = ""
-					p.attrValue = ""
-					p.attrAssigned = false
+		p.isClosable = false
+		if !p.isAttr {
+			p.appendText(text)
+			return
+		}
+		if p.isAmp {
+			p.isAmp = false
+			if tok == TokWord && text == "attributes" {
+				if !p.inMixin() {
+					p.error(p.Scanner.Position, "&attributes outside of a mixin")
 				}
+				p.node.ForwardAttrs = true
+				return
+			}
+			p.beginText()
+			p.appendText("&" + text)
+			return
+		}
+		if tok == '&' && p.attrName == "" {
+			p.isAmp = true
+			return
+		}
+		switch tok {
+		case TokWord, TokInterp:
+			if p.attrAssigned {
+				p.setAttr(text, p.Scanner.Position)
+			} else if tok == TokWord && p.attrName == "" {
+				p.attrName = text
+				p.attrPos = p.Scanner.Position
+				p.attrString = text
 			} else {
-				p.node.text += text
+				p.beginText()
+				p.appendText(text)
 			}
-		case NodeText:
-			p.node.text += text
-		}
-	case TokAssign:
-		switch p.node.Type {
-		case NodeTag:
-			if p.isAttr {
-				if p.attrName != "" && p.attrValue == "" {
-					p.attrAssigned = true
-					p.node.attrString += text
-				}
-				if p.attrName == "" || p.attrValue != "" {
-					p.attrAssigned = false
-					p.isAttr = false
-					p.node.text += p.node.attrString
-					p.node.attrString = ""
+		case TokString, TokInt, TokFloat, TokChar:
+			if p.attrAssigned {
+				pos := p.Scanner.Position
+				if tok == TokString {
+					text = unquote(text)
+					pos = advance(pos, "'")
 				}
+				p.setAttr(text, pos)
 			} else {
-				p.node.text += text
+				p.beginText()
+				p.appendText(text)
 			}
-		case NodeText:
-			p.node.text += text
-		}
-	case TokWhitespace:
-		switch p.node.Type {
-		case NodeTag:
-			if p.isAttr && p.node.attrString != "" {
-				p.node.attrString += text
-
-			// skip initial whitespace for text
-			} else if p.node.text != "" {
-				p.node.text += text
+		case TokAssign:
+			if p.attrName != "" && !p.attrAssigned {
+				p.attrAssigned = true
+				p.attrString += text
+			} else {
+				p.beginText()
+				p.appendText(text)
 			}
-		}
-	case TokStringFlag:
-		switch p.node.Type {
-		case NodeNil:
-			// new node, but indent indicates continue with text of previous node
-			p.node.Type = NodeText
-		case NodeTag:
-			if p.isAttr {
-				p.node.text += p.node.attrString
-				p.node.attrString = ""
+		case TokWhitespace:
+			if p.attrName != "" {
+				p.attrString += text
+			}
+		case TokStringFlag:
+			// everything after the back tick is text
+			p.beginText()
+		case TokRawFlag:
+			if p.attrName == "" {
+				// everything after the flag is text that is not escaped
+				p.beginText()
+				p.isRaw = true
+			} else if !p.attrAssigned && !p.attrRaw {
+				// name!=value assigns a value that is not escaped
+				p.attrRaw = true
+				p.attrString += text
 			} else {
-				p.node.text += text
+				p.beginText()
+				p.appendText(text)
 			}
-			p.isAttr = false
-		}
-	case TokNewLine:
-		// reset
-		p.isIndent = false
-		p.isDedent = false
-		p.isAttr = false
-		p.attrName = ""
-		p.attrValue = ""
-		p.attrAssigned = false
-	case TokEOF:
-		// close the remaining nodes in the stack 
-		for len(p.stack) > 0 {
-			lastNode := p.popNode()
-			p.trimOutput()
-			p.output += lastNode.CloseString()
-		}
-	// nop
-	case TokIndent, TokDedent, TokNodent:
-	default:
-		if !p.isAttr {
-			p.node.text += text
+		default:
+			p.beginText()
+			p.appendText(text)
 		}
 	}
 }
 
+// unquote strips the quotes of a TokString. The literal may be unterminated if
+// the scanner reported an error.
+func unquote(text string) string {
+	if len(text) >= 2 && text[len(text)-1] == text[0] {
+		return text[1:len(text)-1]
+	}
+	return text[1:]
+}
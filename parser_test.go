@@ -0,0 +1,32 @@
+package mite
+
+import (
+	"strings"
+	"testing"
+)
+
+var parseErrorTests = []struct {
+	src string
+	err string
+}{
+	{"doctype xml\ndoctype html", "2:1: duplicate doctype"},
+	{"p\ndoctype html", "2:1: doctype must come before any other content"},
+	{"div\n  doctype html", "2:3: doctype must be at the top level"},
+	{"+", "1:2: expected mixin name after +"},
+	{"div\n  +\np", "2:4: expected mixin name after +"},
+	{"+ card", "1:2: expected mixin name after +"},
+	{"div#a id=b", "1:7: duplicate attribute id"},
+	{"div#a#b", "1:6: duplicate id literal #b"},
+	{"a href=x href=y", "1:10: duplicate attribute href"},
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range parseErrorTests {
+		_, err := Compile(strings.NewReader(test.src))
+		if err == nil {
+			t.Errorf("%q: no error, want %q", test.src, test.err)
+		} else if err.Error() != test.err {
+			t.Errorf("%q: error %q, want %q", test.src, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package mite
+
+import (
+	"bufio"
+	"io"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// voidElements are the HTML elements that never have content or an end tag
+var voidElements = map[string]bool {
+	"area":		true,
+	"base":		true,
+	"br":		true,
+	"col":		true,
+	"embed":	true,
+	"hr":		true,
+	"img":		true,
+	"input":	true,
+	"keygen":	true,
+	"link":		true,
+	"meta":		true,
+	"param":	true,
+	"source":	true,
+	"track":	true,
+	"wbr":		true,
+}
+
+func isVoidElement(tag string) bool {
+	return voidElements[strings.ToLower(tag)]
+}
+
+// renderer writes the HTML for a tree of Nodes.
+type renderer struct {
+	w *bufio.Writer
+
+	// write attributes in alphabetical order instead of source order
+	sortAttrs bool
+
+	// set by doctype nodes for the rest of the render
+	mode OutputMode
+
+	// data passed to Execute that expressions are evaluated against
+	data reflect.Value
+
+	// functions and filters added with Template.Funcs and Template.Filters
+	funcs map[string]reflect.Value
+	filters FilterMap
+
+	// variables in scope, innermost last. they are looked up before the
+	// fields of data
+	vars []variable
+	calls callScopes
+
+	// first error returned by w. once set nothing else is written
+	err error
+}
+
+// variable is a name bound by an each loop or an assignment.
+type variable struct {
+	name string
+	value reflect.Value
+}
+
+// Loop is the value of the loop variable within an each block.
+type Loop struct {
+	// starts at 0
+	Index int
+	First bool
+	Last bool
+}
+
+func newRenderer(w io.Writer) *renderer {
+	return &renderer{w: bufio.NewWriter(w)}
+}
+
+// render writes the HTML for n and its children, and returns the first error
+// encountered while writing.
+func (r *renderer) render(n *Node) error {
+	if err := checkVoid(n, r.mode); err != nil {
+		return err
+	}
+	r.node(n)
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+func (r *renderer) write(s string) {
+	if r.err == nil {
+		_, r.err = r.w.WriteString(s)
+	}
+}
+
+func (r *renderer) node(n *Node) {
+	switch n.Type {
+	case NodeRoot, NodeBlock:
+		if r.calls.isSlot(n) {
+			// the content of the call, which sees the caller's variables
+			r.calls.enterContent(len(r.vars))
+			defer r.calls.leaveContent()
+		}
+		r.children(n)
+	case NodeTag:
+		writeTag(r, n, r.sortAttrs, r.mode)
+	case NodeDoctype:
+		d := lookupDoctype(n.Text)
+		r.mode = d.mode
+		r.write(d.decl)
+	case NodeHTMLComment:
+		writeHTMLComment(r, n)
+	case NodeIf:
+		for c := n; c != nil; c = c.Else {
+			if c.Type == NodeElse {
+				r.children(c)
+				break
+			}
+			v, err := r.eval(c.Expr)
+			if err != nil {
+				r.fail(err)
+				return
+			}
+			if truth(v) {
+				r.children(c)
+				break
+			}
+		}
+	case NodeEach:
+		v, err := r.eval(n.Expr)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		if !r.renderEach(n, v) && n.Else != nil {
+			r.children(n.Else)
+		}
+	case NodeAssign:
+		v, err := r.eval(n.Expr)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		// in scope until the end of the parent's children
+		r.push(n.Var, v)
+	case NodeCall:
+		r.renderCall(n)
+	case NodeFilter:
+		out, err := filterOutput(r.filters, n)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		r.write(out)
+	case NodeText:
+		if n.Raw {
+			r.writeParts(n.Text, n.Parts, nil, true)
+		} else {
+			r.writeParts(n.Text, n.Parts, escapeText, true)
+		}
+	}
+}
+
+func (r *renderer) writeAttr(attr *Attr) {
+	if attr.Raw {
+		r.writeParts(attr.Value, attr.Parts, nil, false)
+	} else {
+		r.writeParts(attr.Value, attr.Parts, escapeAttr, false)
+	}
+}
+
+// writeParts writes text, or its parts if it has interpolations, escaping it
+// with escape unless escape is nil. Interpolated HTML values are not escaped
+// when html is set, which it is for text but not for attribute values.
+func (r *renderer) writeParts(text string, parts []*Part, escape func(string) string, html bool) {
+	if parts == nil {
+		parts = []*Part{{Text: text}}
+	}
+	for _, part := range parts {
+		s := part.Text
+		if part.Expr != nil {
+			v, err := r.eval(part.Expr)
+			if err != nil {
+				r.fail(err)
+				return
+			}
+			s = printValue(v)
+			if u := unwrap(v); html && u.IsValid() && u.Type() == htmlType {
+				r.write(s)
+				continue
+			}
+		}
+		if escape != nil {
+			s = escape(s)
+		}
+		r.write(s)
+	}
+}
+
+// renderEach renders the children of n once for each element of v, and
+// reports whether there were any elements.
+func (r *renderer) renderEach(n *Node, v reflect.Value) bool {
+	v = indirect(v)
+	if !v.IsValid() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len() && r.err == nil; i++ {
+			r.renderIteration(n, v.Index(i), reflect.ValueOf(i), Loop{i, i == 0, i == v.Len()-1})
+		}
+		return v.Len() > 0
+	case reflect.Map:
+		keys := sortedKeys(v)
+		for i := 0; i < len(keys) && r.err == nil; i++ {
+			r.renderIteration(n, v.MapIndex(keys[i]), keys[i], Loop{i, i == 0, i == len(keys)-1})
+		}
+		return len(keys) > 0
+	case reflect.Chan:
+		if v.Type().ChanDir() == reflect.SendDir {
+			break
+		}
+		// receive one element ahead to know which is the last
+		elem, ok := v.Recv()
+		i := 0
+		for ; ok && r.err == nil; i++ {
+			next, nextOK := v.Recv()
+			r.renderIteration(n, elem, reflect.ValueOf(i), Loop{i, i == 0, !nextOK})
+			elem, ok = next, nextOK
+		}
+		return i > 0
+	}
+	r.fail(evalError(n.Expr, "can't iterate over %s of type %s", n.Expr, v.Type()))
+	return true
+}
+
+// renderIteration renders the children of n with its variables bound.
+func (r *renderer) renderIteration(n *Node, elem, key reflect.Value, loop Loop) {
+	mark := len(r.vars)
+	r.push(n.Var, elem)
+	if n.Key != "" {
+		r.push(n.Key, key)
+	}
+	r.push("loop", reflect.ValueOf(loop))
+	r.children(n)
+	r.pop(mark)
+}
+
+// renderCall renders the body of an expanded mixin call with its parameters
+// set. The arguments are evaluated before any parameter is in scope, so they
+// can refer to variables with the same names as the parameters.
+func (r *renderer) renderCall(n *Node) {
+	values := make([]reflect.Value, len(n.Args))
+	for i, arg := range n.Args {
+		v, err := r.eval(arg)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		values[i] = v
+	}
+	mark := len(r.vars)
+	defer r.pop(mark)
+	r.calls.enter(mark)
+	defer r.calls.leave()
+	for i, name := range n.Params {
+		r.push(name, values[i])
+	}
+	r.children(n)
+}
+
+func (r *renderer) push(name string, value reflect.Value) {
+	r.vars = append(r.vars, variable{name, value})
+}
+
+// pop removes the variables pushed since len(r.vars) was mark.
+func (r *renderer) pop(mark int) {
+	r.vars = r.vars[:mark]
+}
+
+// lookupVar returns the innermost variable called name.
+func (r *renderer) lookupVar(name string) (reflect.Value, bool) {
+	for i := len(r.vars) - 1; i >= 0; i-- {
+		if r.vars[i].name == name && !r.calls.isHidden(i) {
+			return r.vars[i].value, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// sortedKeys returns the keys of map v in order. Strings and numbers are
+// compared by value, and other keys by their printed text.
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := indirect(keys[i]), indirect(keys[j])
+		if a.IsValid() && b.IsValid() && a.Kind() == b.Kind() {
+			switch a.Kind() {
+			case reflect.String:
+				return a.String() < b.String()
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return a.Int() < b.Int()
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+				return a.Uint() < b.Uint()
+			case reflect.Float32, reflect.Float64:
+				return a.Float() < b.Float()
+			case reflect.Bool:
+				return !a.Bool() && b.Bool()
+			}
+		}
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	return keys
+}
+
+// fail stops the render with err, unless it was already stopped.
+func (r *renderer) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// children renders the children of n. Variables assigned by them go out of
+// scope at the end.
+func (r *renderer) children(n *Node) {
+	mark := len(r.vars)
+	defer r.pop(mark)
+	writeChildren(r, n)
+}
+
+type attrsByName []*Attr
+
+func (a attrsByName) Len() int           { return len(a) }
+func (a attrsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a attrsByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// sortedAttrs returns a copy of attrs in alphabetical order by name.
+func sortedAttrs(attrs []*Attr) []*Attr {
+	sorted := make(attrsByName, len(attrs))
+	copy(sorted, attrs)
+	sort.Sort(sorted)
+	return sorted
+}
@@ -0,0 +1,117 @@
+package mite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var escapeTests = []struct {
+	src string
+	want string
+}{
+	{"p Tom & Jerry <b>", "<p>Tom &amp; Jerry &lt;b&gt;</p>"},
+	{"p &lt;", "<p>&amp;lt;</p>"},
+	{"p title=\"a'b\" x", "<p title='a&#39;b'>x</p>"},
+	{"p title='<&>' x", "<p title='&lt;&amp;&gt;'>x</p>"},
+	{"p ! <em>x</em> &amp;", "<p><em>x</em> &amp;</p>"},
+	{"a href!='/?a=1&amp;b=2' x", "<a href='/?a=1&amp;b=2'>x</a>"},
+	{"p #{q}", "<p>it's \"&lt;x&gt;\" &amp; y</p>"},
+	{"p title=#{q} x", "<p title='it&#39;s &#34;&lt;x&gt;&#34; &amp; y'>x</p>"},
+	{"p! #{q}", "<p>it's \"<x>\" & y</p>"},
+	{"p #{h}", "<p><i>&amp;</i></p>"},
+	{"p title=#{h} x", "<p title='&lt;i&gt;&amp;amp;&lt;/i&gt;'>x</p>"},
+}
+
+func TestEscape(t *testing.T) {
+	data := map[string]interface{}{
+		"q": "it's \"<x>\" & y",
+		"h": HTML("<i>&amp;</i>"),
+	}
+	for _, test := range escapeTests {
+		tmpl, err := Compile(strings.NewReader(test.src))
+		if err != nil {
+			t.Errorf("%q: %s", test.src, err)
+			continue
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			t.Errorf("%q: %s", test.src, err)
+		} else if b.String() != test.want {
+			t.Errorf("%q: output %q, want %q", test.src, b.String(), test.want)
+		}
+	}
+}
+
+var voidTests = []struct {
+	name string
+	files fstest.MapFS
+	mode OutputMode
+	want string
+	err string
+}{
+	{
+		name: "html",
+		files: fstest.MapFS{"main.mite": {Data: []byte("br text")}},
+		err: "main.mite:1:4: void element br cannot have content",
+	},
+	{
+		name: "xhtml",
+		files: fstest.MapFS{"main.mite": {Data: []byte("doctype strict\nimg\n  p")}},
+		err: "main.mite:3:3: void element img cannot have content",
+	},
+	{
+		name: "template mode",
+		files: fstest.MapFS{"main.mite": {Data: []byte("link\n  span feed")}},
+		mode: ModeXML,
+		want: "<link><span>feed</span></link>",
+	},
+	{
+		name: "included under xml",
+		files: fstest.MapFS{
+			"main.mite": {Data: []byte("doctype xml\nfeed\n  include entry")},
+			"entry.mite": {Data: []byte("link http://example.com/\nbr/")},
+		},
+		want: "<?xml version=\"1.0\" encoding=\"utf-8\" ?><feed><link>http://example.com/</link><br/></feed>",
+	},
+	{
+		name: "extends xml layout",
+		files: fstest.MapFS{
+			"main.mite": {Data: []byte("extends layout\nblock body\n  source x")},
+			"layout.mite": {Data: []byte("doctype xml\nrss\n  block body")},
+		},
+		want: "<?xml version=\"1.0\" encoding=\"utf-8\" ?><rss><source>x</source></rss>",
+	},
+	{
+		name: "empty",
+		files: fstest.MapFS{"main.mite": {Data: []byte("doctype strict\nbr\nhr/")}},
+		want: "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Strict//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd\"><br/><hr/>",
+	},
+}
+
+func TestVoidElements(t *testing.T) {
+	for _, test := range voidTests {
+		tmpl, err := CompileFS(test.files, "main.mite")
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		tmpl.Mode = test.mode
+		var b bytes.Buffer
+		err = tmpl.Execute(&b, nil)
+		if _, genErr := tmpl.TextTemplate(); (err == nil) != (genErr == nil) {
+			t.Errorf("%s: Execute error %v, but TextTemplate error %v", test.name, err, genErr)
+		}
+		switch {
+		case test.err != "":
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case b.String() != test.want:
+			t.Errorf("%s: output %q, want %q", test.name, b.String(), test.want)
+		}
+	}
+}
@@ -23,13 +23,15 @@
 //		tok = s.Scan()
 //	}
 //
-package main
+
+package mite
 
 import (
 	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -81,7 +83,11 @@ const (
 	ScanWhitespace = 1 << -TokWhitespace
 	ScanAssigns	   = 1 << -TokAssign
 	ScanIndents	   = 1 << -TokIndent
-	GoTokens       = ScanWords | ScanFloats | ScanChars | ScanStrings | ScanStringFlags | ScanComments | ScanNewLines | ScanCommas | ScanIndents | ScanWhitespace | ScanAssigns
+	ScanRawFlags   = 1 << -TokRawFlag
+	ScanClasses    = 1 << -TokClass
+	ScanIDs        = 1 << -TokID
+	ScanInterps    = 1 << -TokInterp
+	GoTokens       = ScanWords | ScanFloats | ScanChars | ScanStrings | ScanStringFlags | ScanComments | ScanNewLines | ScanCommas | ScanIndents | ScanWhitespace | ScanAssigns | ScanRawFlags | ScanClasses | ScanIDs | ScanInterps
 )
 
 // The result of Scan is one of the following tokens or a Unicode character.
@@ -101,6 +107,10 @@ const (
 	TokIndent
 	TokDedent
 	TokNodent
+	TokRawFlag
+	TokClass
+	TokID
+	TokInterp
 )
 
 var tokenString = map[rune]string{
@@ -119,6 +129,10 @@ var tokenString = map[rune]string{
 	TokIndent:	  "Indent",
 	TokDedent:	  "Dedent",
 	TokNodent:	  "Nodent",
+	TokRawFlag:	  "RawFlag",
+	TokClass:	  "Class",
+	TokID:		  "ID",
+	TokInterp:	  "Interp",
 }
 
 // TokenString returns a printable string for a token or Unicode character.
@@ -168,6 +182,11 @@ type Scanner struct {
 	// are when indentLevel < lastIndentLevel
 	indents []int
 
+	// set by ScanBlock when it has already scanned the indent of the line
+	// after the block, which is blockIndentLevel
+	blockEnded bool
+	blockIndentLevel int
+
 	// One character look-ahead
 	ch rune // character before current srcPos
 
@@ -183,6 +202,12 @@ type Scanner struct {
 	// changed at any time.
 	Mode uint
 
+	// IsWordRune is a predicate controlling the characters accepted as the
+	// ith rune in a word. If nil, words are Go identifiers: a letter or '_'
+	// followed by letters, digits and '_'. Set it to IsNameRune to scan HTML
+	// and XML names.
+	IsWordRune func(ch rune, i int) bool
+
 	// Start position of most recently scanned token; set by Scan.
 	// Calling Init or Next invalidates the position (Line == 0).
 	// The Filename field is always left untouched by the Scanner.
@@ -216,6 +241,7 @@ func (s *Scanner) Init(src io.Reader) *Scanner {
 
 	s.indentChecked = false
 	s.indentLevel = -1
+	s.blockEnded = false
 	s.lastIndentLevel = 0
 	s.indents = []int{}
 
@@ -226,6 +252,7 @@ func (s *Scanner) Init(src io.Reader) *Scanner {
 	s.Error = nil
 	s.ErrorCount = 0
 	s.Mode = GoTokens
+	s.IsWordRune = nil
 	s.Line = 0 // invalidate token position
 
 	return s
@@ -358,12 +385,29 @@ func (s *Scanner) error(msg string) {
 
 func (s *Scanner) scanWord() rune {
 	ch := s.next() // read character after first '_' or letter
-	for ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch) {
+	for i := 1; s.isWordRune(ch, i); i++ {
 		ch = s.next()
 	}
 	return ch
 }
 
+func (s *Scanner) isWordRune(ch rune, i int) bool {
+	if s.IsWordRune != nil {
+		return s.IsWordRune(ch, i)
+	}
+	return ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch) && i > 0
+}
+
+// IsNameRune reports whether ch can be the ith rune of an HTML or XML name
+// such as data-id, my-widget or xlink:href. Names start like Go identifiers
+// and can also contain '-' and ':' after the first rune.
+func IsNameRune(ch rune, i int) bool {
+	if ch == '_' || unicode.IsLetter(ch) {
+		return true
+	}
+	return i > 0 && (unicode.IsDigit(ch) || ch == '-' || ch == ':')
+}
+
 func digitVal(ch rune) int {
 	switch {
 	case '0' <= ch && ch <= '9':
@@ -500,6 +544,31 @@ func (s *Scanner) scanString(quote rune) (n int) {
 	return
 }
 
+func (s *Scanner) scanInterp() rune {
+	// ch == '{'
+	depth := 0
+	ch := s.next() // read character after "#{"
+	for {
+		switch ch {
+		case '"', '\'':
+			s.scanString(ch)
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return s.next()
+			}
+			depth--
+		}
+		if ch == '\n' || ch < 0 {
+			// not terminated. reported by the parser along with any other
+			// problems in the expression
+			return ch
+		}
+		ch = s.next()
+	}
+}
+
 func (s *Scanner) scanIndent(ch rune) (rune, int) {
 	level := 0
 	for ch == ' ' || ch == '\t' {
@@ -513,10 +582,78 @@ func (s *Scanner) scanIndent(ch rune) (rune, int) {
 	return ch, level
 }
 
+// ScanBlock reads the lines following the current line that are indented more
+// than it, without scanning them for tokens. It must be called right after Scan
+// returns TokNewLine. The lines are returned joined by newlines, with the
+// indentation they have in common removed, so relative indentation is kept.
+// Tabs in the indentation are replaced by spaces. Blank lines at the start and
+// end of the block are left out.
+func (s *Scanner) ScanBlock() string {
+	var lines []string
+	levels := []int{}
+	minLevel := -1
+	ch := s.Peek()
+	for ch >= 0 {
+		var level int
+		ch, level = s.scanIndent(ch)
+		if ch == '\n' || ch == '\r' {
+			lines = append(lines, "")
+			levels = append(levels, 0)
+			ch = s.scanLineEnd(ch)
+			continue
+		}
+		if ch < 0 {
+			break
+		}
+		if level <= s.lastIndentLevel {
+			// the block is over, and this line's indent is already scanned
+			s.blockEnded = true
+			s.blockIndentLevel = level
+			break
+		}
+		var line []rune
+		for ch != '\n' && ch != '\r' && ch >= 0 {
+			line = append(line, ch)
+			ch = s.next()
+		}
+		ch = s.scanLineEnd(ch)
+		lines = append(lines, string(line))
+		levels = append(levels, level)
+		if minLevel == -1 || level < minLevel {
+			minLevel = level
+		}
+	}
+	s.ch = ch
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines, levels = lines[1:], levels[1:]
+	}
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = strings.Repeat(" ", levels[i]-minLevel) + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// scanLineEnd reads past one \n, \r\n or \r line ending.
+func (s *Scanner) scanLineEnd(ch rune) rune {
+	if ch == '\r' {
+		ch = s.next()
+	}
+	if ch == '\n' {
+		ch = s.next()
+	}
+	return ch
+}
+
 func (s *Scanner) scanComment(ch rune) rune {
-	// ch == '/' || ch == '*'
-	if ch == '/' {
-		// line comment
+	// ch == '/' || ch == '*' || ch == '!'
+	if ch == '/' || ch == '!' {
+		// line comment, "//" or "/!"
 		ch = s.next() // read character after "//"
 		for ch != '\n' && ch >= 0 {
 			ch = s.next()
@@ -601,7 +738,16 @@ func (s *Scanner) Scan() []rune {
 	if s.Mode&ScanIndents != 0 && s.indentLevel == -1 && !s.indentChecked {
 		// if indentLevel is -1, treat it as a newline which requires level checking
 		// whitespace is significant ONLY for indents
-		ch, s.indentLevel = s.scanIndent(ch)
+		if s.blockEnded {
+			s.indentLevel = s.blockIndentLevel
+			s.blockEnded = false
+		} else {
+			ch, s.indentLevel = s.scanIndent(ch)
+		}
+		// lines with only whitespace are blank and their indent doesn't count
+		for ch == '\n' || ch == '\r' {
+			ch, s.indentLevel = s.scanIndent(s.scanNewLine(ch))
+		}
 		if s.indentLevel > s.lastIndentLevel {
 			runes = append(runes, TokIndent)
 			// for indents simply add to the indents array
@@ -629,7 +775,7 @@ func (s *Scanner) Scan() []rune {
 	}
 
 	switch {
-	case unicode.IsLetter(ch) || ch == '_':
+	case s.isWordRune(ch, 0):
 		if s.Mode&ScanWords != 0 {
 			tok = TokWord
 			ch = s.scanWord()
@@ -687,10 +833,25 @@ func (s *Scanner) Scan() []rune {
 				tok = TokFloat
 				ch = s.scanMantissa(ch)
 				ch = s.scanExponent(ch)
+			} else if s.isWordRune(ch, 0) && s.Mode&ScanClasses != 0 {
+				// class literal .name
+				tok = TokClass
+				ch = s.scanWord()
+			}
+		case '#':
+			ch = s.next()
+			if s.isWordRune(ch, 0) && s.Mode&ScanIDs != 0 {
+				// id literal #name
+				tok = TokID
+				ch = s.scanWord()
+			} else if ch == '{' && s.Mode&ScanInterps != 0 {
+				// interpolation #{expr}
+				tok = TokInterp
+				ch = s.scanInterp()
 			}
 		case '/':
 			ch = s.next()
-			if (ch == '/' || ch == '*') && s.Mode&ScanComments != 0 {
+			if (ch == '/' || ch == '*' || ch == '!') && s.Mode&ScanComments != 0 {
 				ch = s.scanComment(ch)
 				tok = TokComment
 			}
@@ -699,6 +860,11 @@ func (s *Scanner) Scan() []rune {
 				tok = TokStringFlag
 			}
 			ch = s.next()
+		case '!':
+			if s.Mode&ScanRawFlags != 0 {
+				tok = TokRawFlag
+			}
+			ch = s.next()
 		default:
 			ch = s.next()
 		}
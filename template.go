@@ -0,0 +1,104 @@
+package mite
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// Template is a compiled mite template, ready to be rendered.
+type Template struct {
+	// Name is used as the filename in error positions
+	Name string
+
+	// Root is the node tree of the template. It can be inspected or modified
+	// before calling Render.
+	Root *Node
+
+	// SortAttrs renders attributes in alphabetical order instead of the order
+	// they are written in the template.
+	SortAttrs bool
+
+	// Mode is the output mode used until a doctype line selects another.
+	Mode OutputMode
+
+	// added with Funcs and Filters
+	funcs map[string]reflect.Value
+	filters FilterMap
+}
+
+// Compile reads a mite template from src and parses it. Any scanning or
+// parsing errors are returned as an ErrorList. Included templates are read
+// relative to the current directory.
+func Compile(src io.Reader) (*Template, error) {
+	l := &loader{fsys: os.DirFS(".")}
+	root, err := l.load("", src)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Root: root}, nil
+}
+
+// CompileFile compiles the mite template in the named file. Templates are
+// read from the working directory, so names in include and extends lines can
+// go up to other directories in it with .., and names starting with / are
+// relative to it. A template outside the working directory is read from its
+// own directory instead.
+func CompileFile(filename string) (*Template, error) {
+	dir, name := fileRoot(filename)
+	l := &loader{fsys: os.DirFS(dir), dir: dir}
+	root, err := l.loadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Name: filename, Root: root}, nil
+}
+
+// fileRoot splits filename into the directory that templates are read from
+// for CompileFile, and the name of the template in it.
+func fileRoot(filename string) (dir, name string) {
+	if abs, err := filepath.Abs(filename); err == nil {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, abs); err == nil && filepath.IsLocal(rel) {
+				return ".", filepath.ToSlash(rel)
+			}
+		}
+	}
+	dir, name = filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	return dir, name
+}
+
+// CompileFS compiles the mite template called name in fsys. Names in include
+// lines are relative to the directory of the template they are in, or to the
+// root of fsys if they start with a slash. Positions in errors and nodes have
+// the names of the templates in fsys as the Filename.
+func CompileFS(fsys fs.FS, name string) (*Template, error) {
+	l := &loader{fsys: fsys}
+	root, err := l.loadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Name: name, Root: root}, nil
+}
+
+// Render streams the HTML of the template to w, without any data.
+func (t *Template) Render(w io.Writer) error {
+	return t.Execute(w, nil)
+}
+
+// Execute streams the HTML of the template to w. Interpolated expressions
+// are evaluated against data, usually a struct or a map with string keys.
+func (t *Template) Execute(w io.Writer, data interface{}) error {
+	r := newRenderer(w)
+	r.data = reflect.ValueOf(data)
+	r.sortAttrs = t.SortAttrs
+	r.mode = t.Mode
+	r.funcs = t.funcs
+	r.filters = t.filters
+	return r.render(t.Root)
+}